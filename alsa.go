@@ -0,0 +1,418 @@
+//go:build linux
+
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ALSA sequencer kernel interface, from <sound/asequencer.h>. Talking to
+// /dev/snd/seq directly avoids depending on libasound.
+const (
+	seqEventSize = 28 // struct snd_seq_event
+
+	seqEventNoteOn      = 6
+	seqEventNoteOff     = 7
+	seqEventKeyPress    = 8
+	seqEventController  = 10
+	seqEventPgmChange   = 11
+	seqEventChanPress   = 12
+	seqEventPitchBend   = 13
+	seqEventControl14   = 14
+	seqEventNonRegParam = 15
+	seqEventRegParam    = 16
+	seqEventSongPos     = 20
+	seqEventSongSel     = 21
+	seqEventQFrame      = 22
+	seqEventStart       = 30
+	seqEventContinue    = 31
+	seqEventStop        = 32
+	seqEventClock       = 36
+	seqEventTuneRequest = 40
+	seqEventReset       = 41
+	seqEventSensing     = 42
+	seqEventSysEx       = 130
+	seqEventPortStart   = 63
+
+	seqEventLengthVariable = 1 << 2
+	seqExtMask             = 0xC0000000
+
+	seqPortCapRead      = 1 << 0
+	seqPortCapWrite     = 1 << 1
+	seqPortCapSubsRead  = 1 << 5
+	seqPortCapSubsWrite = 1 << 6
+	seqPortTypeMidi     = 1 << 1
+	seqPortTypeApp      = 1 << 20
+
+	seqAddressSubscribers = 254
+	seqQueueDirect        = 253
+	seqClientSystem       = 0
+	seqPortSystemAnnounce = 1
+)
+
+type seqClientInfo struct {
+	Client          int32
+	Type            int32
+	Name            [64]byte
+	Filter          uint32
+	MulticastFilter [8]byte
+	EventFilter     [32]byte
+	NumPorts        int32
+	EventLost       int32
+	Card            int32
+	Pid             int32
+	Reserved        [56]byte
+}
+
+type seqPortInfo struct {
+	Client, Port uint8
+	Name         [64]byte
+	Capability   uint32
+	Type         uint32
+	MidiChannels int32
+	MidiVoices   int32
+	SynthVoices  int32
+	ReadUse      int32
+	WriteUse     int32
+	Kernel       uintptr
+	Flags        uint32
+	TimeQueue    uint8
+	Reserved     [59]byte
+}
+
+func seqIoctl(dir, nr, size uintptr) uintptr {
+	return dir<<30 | size<<16 | 'S'<<8 | nr
+}
+
+type seqPortSubscribe struct {
+	Sender, Dest [2]uint8 // client, port
+	Voices       uint32
+	Flags        uint32
+	Queue        uint8
+	Pad          [3]byte
+	Reserved     [64]byte
+}
+
+var (
+	seqIoctlClientID        = seqIoctl(2, 0x01, 4)
+	seqIoctlGetClientInfo   = seqIoctl(3, 0x10, unsafe.Sizeof(seqClientInfo{}))
+	seqIoctlSetClientInfo   = seqIoctl(1, 0x11, unsafe.Sizeof(seqClientInfo{}))
+	seqIoctlCreatePort      = seqIoctl(3, 0x20, unsafe.Sizeof(seqPortInfo{}))
+	seqIoctlGetAnyPortInfo  = seqIoctl(3, 0x23, unsafe.Sizeof(seqPortInfo{}))
+	seqIoctlSubscribePort   = seqIoctl(1, 0x30, unsafe.Sizeof(seqPortSubscribe{}))
+	seqIoctlQueryNextClient = seqIoctl(3, 0x51, unsafe.Sizeof(seqClientInfo{}))
+	seqIoctlQueryNextPort   = seqIoctl(3, 0x52, unsafe.Sizeof(seqPortInfo{}))
+)
+
+func init() {
+	inputs["alsa"] = func(name string) (midiInput, error) { return openALSA(name) }
+}
+
+// alsaSeq is a client of the ALSA sequencer with a midi_in and a midi_out
+// port, connected with aconnect or a patchbay.
+type alsaSeq struct {
+	f       *os.File
+	client  int32
+	in, out uint8
+	parser  midiParser
+}
+
+func ioctl(f *os.File, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, f.Fd(), req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// openALSA registers a sequencer client and its ports.
+func openALSA(name string) (*alsaSeq, error) {
+	f, err := os.OpenFile("/dev/snd/seq", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	s := &alsaSeq{f: f}
+	if err := ioctl(f, seqIoctlClientID, unsafe.Pointer(&s.client)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("client id: %w", err)
+	}
+	info := seqClientInfo{Client: s.client, Type: 1} // user client
+	copy(info.Name[:], name)
+	if err := ioctl(f, seqIoctlSetClientInfo, unsafe.Pointer(&info)); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("client name: %w", err)
+	}
+	if s.in, err = s.createPort("midi_in", seqPortCapWrite|seqPortCapSubsWrite); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if s.out, err = s.createPort("midi_out", seqPortCapRead|seqPortCapSubsRead); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *alsaSeq) createPort(name string, caps uint32) (uint8, error) {
+	info := seqPortInfo{
+		Client:       uint8(s.client),
+		Capability:   caps,
+		Type:         seqPortTypeMidi | seqPortTypeApp,
+		MidiChannels: 16,
+	}
+	copy(info.Name[:], name)
+	if err := ioctl(s.f, seqIoctlCreatePort, unsafe.Pointer(&info)); err != nil {
+		return 0, fmt.Errorf("port %s: %w", name, err)
+	}
+	slog.Info("Registered ALSA sequencer port", slog.String("name", fmt.Sprintf("%d:%d %s", s.client, info.Port, name)))
+	return info.Port, nil
+}
+
+func (s *alsaSeq) start() error {
+	// Announces the ports appearing, e.g. a controller plugged in
+	if err := s.subscribe([2]uint8{seqClientSystem, seqPortSystemAnnounce}); err != nil {
+		slog.Warn("No ALSA sequencer announcements, plugged devices are not connected", slog.Any("err", err))
+	}
+	s.connectPorts()
+	go s.run()
+	go s.writeLoop()
+	return nil
+}
+
+// subscribe connects a port to midi_in.
+func (s *alsaSeq) subscribe(sender [2]uint8) error {
+	sub := seqPortSubscribe{Sender: sender, Dest: [2]uint8{uint8(s.client), s.in}}
+	return ioctl(s.f, seqIoctlSubscribePort, unsafe.Pointer(&sub))
+}
+
+// portName returns the "client:port" name of a port, matched by the
+// connect patterns, and whether it is a readable port of another client.
+func (s *alsaSeq) portName(addr [2]uint8) (string, bool) {
+	port := seqPortInfo{Client: addr[0], Port: addr[1]}
+	if int32(addr[0]) == s.client || ioctl(s.f, seqIoctlGetAnyPortInfo, unsafe.Pointer(&port)) != nil {
+		return "", false
+	}
+	client := seqClientInfo{Client: int32(addr[0])}
+	if ioctl(s.f, seqIoctlGetClientInfo, unsafe.Pointer(&client)) != nil {
+		return "", false
+	}
+	readable := port.Capability&(seqPortCapRead|seqPortCapSubsRead) == seqPortCapRead|seqPortCapSubsRead
+	return cString(client.Name[:]) + ":" + cString(port.Name[:]), readable
+}
+
+// attach connects a port to midi_in when it matches the connect patterns.
+func (s *alsaSeq) attach(addr [2]uint8) {
+	name, ok := s.portName(addr)
+	if !ok {
+		return
+	}
+	cfgMu.RLock()
+	rules := activeConfig.Load().connect
+	cfgMu.RUnlock()
+	for _, r := range rules {
+		if r.port != 1 || !r.re.MatchString(name) {
+			continue
+		}
+		if err := s.subscribe(addr); err != nil {
+			slog.Warn("Failed to connect MIDI port", slog.String("src", name), slog.Any("err", err))
+			return
+		}
+		slog.Info("Connected MIDI port", slog.String("src", name))
+		requestIdentity()
+		return
+	}
+}
+
+// connectPorts attaches the ports present at start.
+func (s *alsaSeq) connectPorts() {
+	client := seqClientInfo{Client: -1}
+	for ioctl(s.f, seqIoctlQueryNextClient, unsafe.Pointer(&client)) == nil {
+		port := seqPortInfo{Client: uint8(client.Client), Port: 255}
+		for ioctl(s.f, seqIoctlQueryNextPort, unsafe.Pointer(&port)) == nil {
+			s.attach([2]uint8{port.Client, port.Port})
+		}
+	}
+}
+
+func cString(b []byte) string {
+	if i := slices.Index(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func (s *alsaSeq) close() {
+	s.f.Close()
+}
+
+// run reads the sequencer events until the client is closed, mapping them
+// as the JACK process callback does.
+func (s *alsaSeq) run() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := s.f.Read(buf)
+		if err != nil {
+			slog.Error("ALSA sequencer read failed", slog.Any("err", err))
+			return
+		}
+		cfgMu.RLock()
+		for b := buf[:n]; len(b) >= seqEventSize; {
+			size := seqEventSize
+			if b[1]&seqEventLengthVariable != 0 {
+				size += int(binary.NativeEndian.Uint32(b[16:]) &^ seqExtMask)
+			}
+			if size > len(b) {
+				break
+			}
+			if b[0] == seqEventPortStart {
+				go s.attach([2]uint8{b[16], b[17]})
+			} else if activeConfig.Load() != nil {
+				s.parser.feed(seqEventMidi(b[:size]), handleMessage)
+			}
+			b = b[size:]
+		}
+		cfgMu.RUnlock()
+	}
+}
+
+// seqEventMidi converts a sequencer event to raw MIDI, nothing for the
+// events without a MIDI equivalent.
+func seqEventMidi(ev []byte) []byte {
+	data := ev[16:seqEventSize]
+	ch := data[0] & 0x0F
+	param := binary.NativeEndian.Uint32(data[4:])
+	value := int32(binary.NativeEndian.Uint32(data[8:]))
+	cc := func(n uint32, v int32) []byte { return []byte{0xB0 | ch, byte(n) & 0x7F, byte(v) & 0x7F} }
+	switch ev[0] {
+	case seqEventNoteOn:
+		return []byte{0x90 | ch, data[1], data[2]}
+	case seqEventNoteOff:
+		return []byte{0x80 | ch, data[1], data[2]}
+	case seqEventKeyPress:
+		return []byte{0xA0 | ch, data[1], data[2]}
+	case seqEventController:
+		return cc(param, value)
+	case seqEventPgmChange:
+		return []byte{0xC0 | ch, byte(value) & 0x7F}
+	case seqEventChanPress:
+		return []byte{0xD0 | ch, byte(value) & 0x7F}
+	case seqEventPitchBend:
+		v := value + 8192
+		return []byte{0xE0 | ch, byte(v) & 0x7F, byte(v>>7) & 0x7F}
+	case seqEventControl14:
+		if param >= 32 {
+			return cc(param, value)
+		}
+		return append(cc(param, value>>7), cc(param+32, value)...)
+	case seqEventNonRegParam, seqEventRegParam:
+		msb, lsb := uint32(99), uint32(98)
+		if ev[0] == seqEventRegParam {
+			msb, lsb = 101, 100
+		}
+		b := append(cc(msb, int32(param>>7)), cc(lsb, int32(param))...)
+		return append(append(b, cc(6, value>>7)...), cc(38, value)...)
+	case seqEventSongPos:
+		return []byte{0xF2, byte(value) & 0x7F, byte(value>>7) & 0x7F}
+	case seqEventSongSel:
+		return []byte{0xF3, byte(value) & 0x7F}
+	case seqEventQFrame:
+		return []byte{0xF1, byte(value) & 0x7F}
+	case seqEventStart:
+		return []byte{0xFA}
+	case seqEventContinue:
+		return []byte{0xFB}
+	case seqEventStop:
+		return []byte{0xFC}
+	case seqEventClock:
+		return []byte{0xF8}
+	case seqEventTuneRequest:
+		return []byte{0xF6}
+	case seqEventReset:
+		return []byte{0xFF}
+	case seqEventSensing:
+		return []byte{0xFE}
+	case seqEventSysEx:
+		// Long SysEx come in several events, joined by the parser
+		return ev[seqEventSize:]
+	}
+	return nil
+}
+
+// writeLoop sends the queued MIDI messages to the subscribers of midi_out.
+func (s *alsaSeq) writeLoop() {
+	for msg := range midiOut {
+		ev, ok := s.event(msg)
+		if !ok {
+			slog.Warn("No ALSA sequencer event for MIDI message", slog.String("msg", fmt.Sprintf("% X", msg)))
+			continue
+		}
+		if _, err := s.f.Write(ev); err != nil {
+			slog.Error("ALSA sequencer write failed", slog.Any("err", err))
+		}
+	}
+}
+
+// event converts a complete MIDI message to a direct sequencer event from
+// midi_out.
+func (s *alsaSeq) event(msg []byte) ([]byte, bool) {
+	if len(msg) == 0 {
+		return nil, false
+	}
+	ev := make([]byte, seqEventSize)
+	ev[3] = seqQueueDirect
+	ev[12], ev[13] = byte(s.client), s.out
+	ev[14] = seqAddressSubscribers
+	data := ev[16:]
+	ctrl := func(t byte, param uint32, value int32) {
+		ev[0] = t
+		data[0] = msg[0] & 0x0F
+		binary.NativeEndian.PutUint32(data[4:], param)
+		binary.NativeEndian.PutUint32(data[8:], uint32(value))
+	}
+	arg := func(i int) byte {
+		if i < len(msg) {
+			return msg[i]
+		}
+		return 0
+	}
+	switch status := msg[0]; {
+	case status == 0xF0:
+		ev[0] = seqEventSysEx
+		ev[1] = seqEventLengthVariable
+		binary.NativeEndian.PutUint32(data[0:], uint32(len(msg)))
+		return append(ev, msg...), true
+	case status >= 0xF0:
+		types := map[byte]byte{0xF1: seqEventQFrame, 0xF2: seqEventSongPos, 0xF3: seqEventSongSel, 0xF6: seqEventTuneRequest,
+			0xF8: seqEventClock, 0xFA: seqEventStart, 0xFB: seqEventContinue, 0xFC: seqEventStop, 0xFE: seqEventSensing, 0xFF: seqEventReset}
+		t, ok := types[status]
+		if !ok {
+			return nil, false
+		}
+		value := int32(arg(1))
+		if status == 0xF2 {
+			value |= int32(arg(2)) << 7
+		}
+		ctrl(t, 0, value)
+		data[0] = 0
+	case status&0xF0 == 0x90, status&0xF0 == 0x80, status&0xF0 == 0xA0:
+		ev[0] = map[byte]byte{0x90: seqEventNoteOn, 0x80: seqEventNoteOff, 0xA0: seqEventKeyPress}[status&0xF0]
+		data[0], data[1], data[2] = status&0x0F, arg(1), arg(2)
+	case status&0xF0 == 0xB0:
+		ctrl(seqEventController, uint32(arg(1)), int32(arg(2)))
+	case status&0xF0 == 0xC0:
+		ctrl(seqEventPgmChange, 0, int32(arg(1)))
+	case status&0xF0 == 0xD0:
+		ctrl(seqEventChanPress, 0, int32(arg(1)))
+	case status&0xF0 == 0xE0:
+		ctrl(seqEventPitchBend, 0, int32(arg(1))|int32(arg(2))<<7-8192)
+	default:
+		return nil, false
+	}
+	return ev, true
+}
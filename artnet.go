@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	artnetPort      = "6454"
+	artnetBroadcast = "255.255.255.255:" + artnetPort
+	artnetRefresh   = time.Second // nodes drop a universe not refreshed for a few seconds
+)
+
+// artnetUniverse is the last DMX frame sent to a universe of a node.
+type artnetUniverse struct {
+	addr *net.UDPAddr
+	num  uint16
+	seq  byte
+	dmx  [512]byte
+	size int // channels sent, up to the highest one set
+}
+
+var (
+	artnetMu        sync.Mutex
+	artnetConn      *net.UDPConn
+	artnetUniverses = map[string]*artnetUniverse{} // keyed by target and universe
+)
+
+// send sets the DMX channel of an action triggered by ev, by default to the
+// value of the event scaled to 0-255, and sends the universe.
+func (aa *ArtNetAction) send(ev MidiEvent) {
+	if err := aa.set(ev); err != nil {
+		slog.Error("Failed to send Art-Net", ev.mappingAttr(), slog.Any("err", err))
+	}
+}
+
+func (aa *ArtNetAction) set(ev MidiEvent) error {
+	if aa.Channel < 1 || aa.Channel > 512 {
+		return fmt.Errorf("DMX channel %d out of 1-512", aa.Channel)
+	}
+	if aa.Universe > 0x7FFF {
+		return fmt.Errorf("universe %d out of 0-32767", aa.Universe)
+	}
+	full := ev.Max
+	if full == 0 {
+		full = 127
+	}
+	level := float64(ev.Value) * 255 / float64(full)
+	if aa.Value != nil {
+		val := resolveValue(aa.Value, ev)
+		f, ok := toFloat(val)
+		if !ok {
+			return fmt.Errorf("invalid DMX value: %v", val)
+		}
+		level = f
+	}
+	dmx := byte(math.Round(min(max(level, 0), 255)))
+
+	target := artnetBroadcast
+	if aa.Target != "" {
+		target = artnetAddr(aa.Target)
+	}
+
+	artnetMu.Lock()
+	defer artnetMu.Unlock()
+	if artnetConn == nil {
+		conn, err := net.ListenUDP("udp4", nil)
+		if err != nil {
+			return err
+		}
+		artnetConn = conn
+		go artnetKeepAlive()
+	}
+	key := fmt.Sprintf("%s/%d", target, aa.Universe)
+	u, ok := artnetUniverses[key]
+	if !ok {
+		addr, err := net.ResolveUDPAddr("udp4", target)
+		if err != nil {
+			return err
+		}
+		u = &artnetUniverse{addr: addr, num: aa.Universe}
+		artnetUniverses[key] = u
+	}
+	u.dmx[aa.Channel-1] = dmx
+	// Even length, as required by ArtDmx
+	u.size = max(u.size, aa.Channel+aa.Channel%2)
+	slog.Debug("Art-Net", ev.mappingAttr(), slog.String("target", target), slog.Int("universe", int(aa.Universe)), slog.Int("channel", aa.Channel), slog.Int("value", int(dmx)))
+	return u.send()
+}
+
+// artnetAddr adds the Art-Net port to a target without one, a host name
+// or an IPv4 or IPv6 address, bracketed or not.
+func artnetAddr(target string) string {
+	if _, _, err := net.SplitHostPort(target); err == nil {
+		return target
+	}
+	return net.JoinHostPort(strings.Trim(target, "[]"), artnetPort)
+}
+
+// send writes an ArtDmx packet, artnetMu held.
+func (u *artnetUniverse) send() error {
+	u.seq++
+	if u.seq == 0 { // 0 disables sequencing
+		u.seq = 1
+	}
+	b := append([]byte("Art-Net\x00"), 0x00, 0x50) // OpDmx, little endian
+	b = append(b, 0, 14, u.seq, 0)                 // protocol version 14, physical port
+	b = binary.LittleEndian.AppendUint16(b, u.num) // SubUni then Net
+	b = binary.BigEndian.AppendUint16(b, uint16(u.size))
+	b = append(b, u.dmx[:u.size]...)
+	_, err := artnetConn.WriteToUDP(b, u.addr)
+	return err
+}
+
+func artnetKeepAlive() {
+	for range time.Tick(artnetRefresh) {
+		artnetMu.Lock()
+		for key, u := range artnetUniverses {
+			if err := u.send(); err != nil {
+				slog.Warn("Failed to refresh Art-Net universe", slog.String("universe", key), slog.Any("err", err))
+			}
+		}
+		artnetMu.Unlock()
+	}
+}
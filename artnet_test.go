@@ -0,0 +1,21 @@
+package main
+
+import "testing"
+
+func TestArtnetAddr(t *testing.T) {
+	tests := []struct{ target, want string }{
+		{"192.168.1.50", "192.168.1.50:6454"},
+		{"192.168.1.50:6455", "192.168.1.50:6455"},
+		{"node.local", "node.local:6454"},
+		{"node.local:6454", "node.local:6454"},
+		{"fe80::1", "[fe80::1]:6454"},
+		{"::1", "[::1]:6454"},
+		{"[::1]", "[::1]:6454"},
+		{"[::1]:6455", "[::1]:6455"},
+	}
+	for _, tt := range tests {
+		if got := artnetAddr(tt.target); got != tt.want {
+			t.Errorf("artnetAddr(%q) = %q, want %q", tt.target, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+// activeBank is the selected bank, starting at 1.
+var activeBank atomic.Int32
+
+func init() {
+	activeBank.Store(1)
+}
+
+// inBank reports whether a mapping is active in the selected bank.
+func (m *Mapping) inBank() bool {
+	return m.Bank == 0 || activeConfig.Load().Banks == nil || int32(m.Bank) == activeBank.Load()
+}
+
+// handle switches banks when buf comes from one of the bank controls and
+// reports whether it did, the message being then consumed.
+func (b *Banks) handle(channel uint8, buf []byte) bool {
+	if b.Channel != nil && uint8(*b.Channel) != channel || len(buf) < 3 {
+		return false
+	}
+	// Bank controls act on press only
+	pressed := buf[2] > 0 && buf[0]&0xF0 != 0x80
+	switch {
+	case b.Next.match(buf):
+		if pressed {
+			setBank(int(activeBank.Load())%b.count() + 1)
+		}
+	case b.Prev.match(buf):
+		if pressed {
+			setBank((int(activeBank.Load())+b.count()-2)%b.count() + 1)
+		}
+	default:
+		for i := range b.Select {
+			if b.Select[i].match(buf) {
+				if pressed {
+					setBank(i + 1)
+				}
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func (b *Banks) count() int {
+	return max(b.Count, len(b.Select), 1)
+}
+
+func (c *BankControl) match(buf []byte) bool {
+	if c == nil {
+		return false
+	}
+	switch buf[0] & 0xF0 {
+	case 0xB0:
+		return c.CC != nil && byte(*c.CC) == buf[1]
+	case 0x90, 0x80:
+		return c.Note != nil && byte(*c.Note) == buf[1]
+	}
+	return false
+}
+
+// setBank selects a bank and notifies the OSC targets of the change.
+func setBank(bank int) {
+	cfg := activeConfig.Load()
+	b := cfg.Banks
+	if b == nil {
+		slog.Warn("No banks configured")
+		return
+	}
+	if bank < 1 || bank > b.count() {
+		slog.Warn("Bank out of range", slog.Int("bank", bank), slog.Int("count", b.count()))
+		return
+	}
+	if activeBank.Swap(int32(bank)) == int32(bank) {
+		return
+	}
+	path := b.Path
+	if path == "" {
+		path = "/bank"
+	}
+	dispatch(MidiEvent{
+		Target:  cfg.OscTarget,
+		Actions: []OSCAction{{Path: path, Type: "i", Value: bank}},
+	})
+}
@@ -0,0 +1,284 @@
+//go:build linux
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// MIDI over Bluetooth LE through the D-Bus API of BlueZ. The device is the
+// first one announcing the BLE MIDI service whose name or address contains
+// -input-port. It is connected again when it comes back after sleeping.
+const (
+	bluez             = "org.bluez"
+	bluezAdapter      = "org.bluez.Adapter1"
+	bluezDevice       = "org.bluez.Device1"
+	bluezChar         = "org.bluez.GattCharacteristic1"
+	propertiesChanged = "org.freedesktop.DBus.Properties.PropertiesChanged"
+
+	bleMidiService = "03b80e5a-ede8-4b33-a751-6ce34ec4c700"
+	bleMidiChar    = "7772e5db-3868-4112-a1a9-f2669d106bf3"
+
+	bleRetry = 3 * time.Second
+)
+
+func init() {
+	inputs["ble"] = func(name string) (midiInput, error) { return openBLE() }
+}
+
+type bluezObjects map[dbus.ObjectPath]map[string]map[string]dbus.Variant
+
+type bleMidi struct {
+	conn    *dbus.Conn
+	signals chan *dbus.Signal
+	parser  midiParser
+	epoch   time.Time
+
+	mu   sync.Mutex
+	char dbus.BusObject // nil while disconnected
+	mtu  int
+}
+
+func openBLE() (*bleMidi, error) {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return nil, err
+	}
+	b := &bleMidi{conn: conn, signals: make(chan *dbus.Signal, 64), epoch: time.Now()}
+	if _, err := b.objects(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("BlueZ: %w", err)
+	}
+	err = conn.AddMatchSignal(dbus.WithMatchSender(bluez), dbus.WithMatchMember("PropertiesChanged"))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.Signal(b.signals)
+	return b, nil
+}
+
+func (b *bleMidi) start() error {
+	go b.run()
+	go b.writeLoop()
+	return nil
+}
+
+func (b *bleMidi) close() {
+	b.conn.Close()
+}
+
+func (b *bleMidi) objects() (bluezObjects, error) {
+	var objs bluezObjects
+	err := b.conn.Object(bluez, "/").Call("org.freedesktop.DBus.ObjectManager.GetManagedObjects", 0).Store(&objs)
+	return objs, err
+}
+
+func (b *bleMidi) run() {
+	for {
+		if err := b.session(); err != nil {
+			if errors.Is(err, dbus.ErrClosed) {
+				return
+			}
+			slog.Warn("BLE MIDI device unavailable, retrying", slog.Any("err", err))
+		}
+		time.Sleep(bleRetry)
+	}
+}
+
+// session connects the device and maps its notifications until it
+// disconnects.
+func (b *bleMidi) session() error {
+	dev, name, err := b.findDevice()
+	if err != nil {
+		return err
+	}
+	if err := b.conn.Object(bluez, dev).Call(bluezDevice+".Connect", 0).Err; err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	path, err := b.findChar(dev)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	char := b.conn.Object(bluez, path)
+	mtu := 23
+	if v, err := char.GetProperty(bluezChar + ".MTU"); err == nil {
+		if m, ok := v.Value().(uint16); ok {
+			mtu = int(m)
+		}
+	}
+	if err := char.Call(bluezChar+".StartNotify", 0).Err; err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	b.mu.Lock()
+	b.char, b.mtu = char, mtu
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.char = nil
+		b.mu.Unlock()
+	}()
+	slog.Info("BLE MIDI device connected", slog.String("name", name), slog.Int("mtu", mtu))
+
+	for sig := range b.signals {
+		if sig.Name != propertiesChanged || len(sig.Body) < 2 {
+			continue
+		}
+		changed, _ := sig.Body[1].(map[string]dbus.Variant)
+		switch sig.Path {
+		case path:
+			if v, ok := changed["Value"]; ok {
+				data, _ := v.Value().([]byte)
+				b.receive(data)
+			}
+		case dev:
+			if v, ok := changed["Connected"]; ok && v.Value() == false {
+				slog.Info("BLE MIDI device disconnected", slog.String("name", name))
+				return nil
+			}
+		}
+	}
+	return dbus.ErrClosed
+}
+
+// findDevice returns the first known device matching, scanning for it when
+// there is none yet.
+func (b *bleMidi) findDevice() (dbus.ObjectPath, string, error) {
+	objs, err := b.objects()
+	if err != nil {
+		return "", "", err
+	}
+	paths := make([]dbus.ObjectPath, 0, len(objs))
+	for path := range objs {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	var adapters []dbus.ObjectPath
+	for _, path := range paths {
+		if _, ok := objs[path][bluezAdapter]; ok {
+			adapters = append(adapters, path)
+		}
+		dev, ok := objs[path][bluezDevice]
+		if !ok {
+			continue
+		}
+		uuids, _ := dev["UUIDs"].Value().([]string)
+		name, _ := dev["Alias"].Value().(string)
+		addr, _ := dev["Address"].Value().(string)
+		if slices.Contains(uuids, bleMidiService) && (strings.Contains(name, inputPort) || strings.Contains(addr, inputPort)) {
+			return path, name, nil
+		}
+	}
+	for _, adapter := range adapters {
+		b.conn.Object(bluez, adapter).Call(bluezAdapter+".StartDiscovery", 0)
+	}
+	return "", "", errors.New("no BLE MIDI device found, scanning")
+}
+
+// findChar waits for the services of the device to be resolved and
+// returns its MIDI characteristic.
+func (b *bleMidi) findChar(dev dbus.ObjectPath) (dbus.ObjectPath, error) {
+	for range 10 {
+		objs, err := b.objects()
+		if err != nil {
+			return "", err
+		}
+		for path, ifaces := range objs {
+			char, ok := ifaces[bluezChar]
+			if ok && strings.HasPrefix(string(path), string(dev)+"/") && char["UUID"].Value() == bleMidiChar {
+				return path, nil
+			}
+		}
+		time.Sleep(time.Second)
+	}
+	return "", errors.New("no BLE MIDI characteristic")
+}
+
+func (b *bleMidi) receive(packet []byte) {
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	if activeConfig.Load() != nil {
+		b.parser.feed(bleMidiStream(packet), handleMessage)
+	}
+}
+
+// bleMidiStream drops the header and the timestamps of a BLE MIDI packet:
+// a byte with the high bit set is a timestamp when it starts a message and
+// a status byte when it follows a timestamp.
+func bleMidiStream(packet []byte) []byte {
+	if len(packet) < 2 || packet[0]&0xC0 != 0x80 {
+		return nil
+	}
+	var out []byte
+	for i := 1; i < len(packet); {
+		if packet[i]&0x80 != 0 {
+			i++
+			if i < len(packet) && packet[i]&0x80 != 0 {
+				out = append(out, packet[i])
+				i++
+			}
+		}
+		for ; i < len(packet) && packet[i]&0x80 == 0; i++ {
+			out = append(out, packet[i])
+		}
+	}
+	return out
+}
+
+// blePackets splits a MIDI message in BLE MIDI packets of at most size
+// bytes, a long SysEx being continued in several.
+func blePackets(msg []byte, ms uint16, size int) [][]byte {
+	header, ts := 0x80|byte(ms>>7)&0x3F, 0x80|byte(ms)&0x7F
+	if msg[0] != 0xF0 || len(msg)+2 <= size {
+		if msg[0] == 0xF0 && msg[len(msg)-1] == 0xF7 {
+			return [][]byte{append(append([]byte{header, ts}, msg[:len(msg)-1]...), ts, 0xF7)}
+		}
+		return [][]byte{append([]byte{header, ts}, msg...)}
+	}
+	var packets [][]byte
+	data := msg[:len(msg)-1]
+	p := []byte{header, ts}
+	for len(data) > 0 {
+		n := min(size-len(p), len(data))
+		p = append(p, data[:n]...)
+		data = data[n:]
+		if len(data) > 0 {
+			packets = append(packets, p)
+			p = []byte{header}
+		}
+	}
+	if len(p)+2 > size {
+		packets = append(packets, p)
+		p = []byte{header}
+	}
+	return append(packets, append(p, ts, 0xF7))
+}
+
+// writeLoop sends the queued MIDI messages to the device, dropping them
+// while it is disconnected.
+func (b *bleMidi) writeLoop() {
+	opts := map[string]dbus.Variant{"type": dbus.MakeVariant("command")}
+	for msg := range midiOut {
+		b.mu.Lock()
+		char, mtu := b.char, b.mtu
+		b.mu.Unlock()
+		if char == nil || len(msg) == 0 {
+			continue
+		}
+		ms := uint16(time.Since(b.epoch).Milliseconds() % 8192)
+		for _, p := range blePackets(msg, ms, mtu-3) {
+			if err := char.Call(bluezChar+".WriteValue", 0, p, opts).Err; err != nil {
+				slog.Error("BLE MIDI write failed", slog.Any("err", err))
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// connectFlag holds the -connect flags, added to connect of the config.
+var connectFlag connectFlags
+
+type connectFlags []string
+
+func (c *connectFlags) String() string { return strings.Join(*c, " ") }
+
+func (c *connectFlags) Set(s string) error {
+	*c = append(*c, s)
+	return nil
+}
+
+// connectRule connects the MIDI outputs whose full name matches re to the
+// input port, 1-based.
+type connectRule struct {
+	port int
+	re   *regexp.Regexp
+}
+
+// resolveConnect compiles the connect patterns, "device=pattern" choosing
+// the input port of a device of midi_inputs.
+func (c *Config) resolveConnect() error {
+	c.connect = nil
+	for _, s := range append(slices.Clone(c.Connect), connectFlag...) {
+		port := 1
+		if dev, pattern, ok := strings.Cut(s, "="); ok {
+			if n := slices.Index(c.MidiInputs, dev); n >= 0 {
+				port, s = n+1, pattern
+			}
+		}
+		re, err := regexp.Compile("^(?:" + s + ")$")
+		if err != nil {
+			return fmt.Errorf("connect %q: %w", s, err)
+		}
+		c.connect = append(c.connect, connectRule{port, re})
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/hypebeast/go-osc/osc"
+)
+
+// controlPrefix is the address space of the remote control API served on
+// the feedback server.
+const controlPrefix = "/midi2osc/"
+
+// muted stops all output while set.
+var muted atomic.Bool
+
+var errNoConfigFile = errors.New("running the embedded config, no file to reload")
+
+// handleOSC dispatches a message received by the OSC server to the remote
+// control API or to the feedback mappings.
+// Replies go back to the sender.
+func handleOSC(msg *osc.Message, reply func(*message)) {
+	if cmd, ok := strings.CutPrefix(msg.Address, controlPrefix); ok {
+		handleControl(cmd, msg.Arguments, reply)
+		return
+	}
+	if fb := activeConfig.Load().Feedback; fb != nil {
+		fb.handle(msg)
+	}
+}
+
+// controlCommands describes the commands of handleControl and their reply
+// for OSCQuery.
+var controlCommands = []struct {
+	name, typ, desc string
+	reply           bool // sent by midi2osc
+}{
+	{name: "reload", desc: "Reload the config file"},
+	{name: "mute", typ: "i", desc: "Stop (1) or resume (0) output, toggle without argument"},
+	{name: "bank", typ: "i", desc: "Select a bank"},
+	{name: "profile", typ: "s", desc: "Switch to a profile"},
+	{name: "tag", typ: "si", desc: "Disable (0) or enable (1) the mappings with a tag"},
+	{name: "toggles", desc: "Reply the state of each toggle mapping"},
+	{name: "toggle", typ: "si", desc: "State of a toggle mapping, in reply to toggles", reply: true},
+}
+
+// handleControl runs a remote control command:
+//
+//	/midi2osc/reload          reload the config file
+//	/midi2osc/mute [0|1]      stop or resume output, toggle without argument
+//	/midi2osc/bank <n>        select bank n
+//	/midi2osc/profile <name>  switch to a profile
+//	/midi2osc/tag <tag> <0|1> disable or enable the mappings with a tag
+//	/midi2osc/toggles         reply /midi2osc/toggle <key> <0|1> for each toggle mapping
+func handleControl(cmd string, args []interface{}, reply func(*message)) {
+	switch cmd {
+	case "reload":
+		reload()
+	case "mute":
+		mute := !muted.Load()
+		if len(args) > 0 {
+			v, ok := oscNumber(args[0])
+			if !ok {
+				slog.Warn("Invalid mute argument", slog.Any("val", args[0]))
+				return
+			}
+			mute = v != 0
+		}
+		muted.Store(mute)
+		slog.Info("Output muted", slog.Bool("muted", mute))
+	case "bank":
+		if len(args) == 0 {
+			slog.Warn("Missing bank number")
+			return
+		}
+		bank, ok := oscNumber(args[0])
+		if !ok {
+			slog.Warn("Invalid bank number", slog.Any("val", args[0]))
+			return
+		}
+		setBank(int(bank))
+	case "profile":
+		name, ok := "", len(args) > 0
+		if ok {
+			name, ok = args[0].(string)
+		}
+		if !ok {
+			slog.Warn("Expected a profile name", slog.Any("args", args))
+			return
+		}
+		setProfile(name)
+	case "tag":
+		if len(args) < 2 {
+			slog.Warn("Expected a tag and 0 or 1")
+			return
+		}
+		tag, ok := args[0].(string)
+		enable, ok2 := oscNumber(args[1])
+		if !ok || !ok2 {
+			slog.Warn("Invalid tag arguments", slog.Any("args", args))
+			return
+		}
+		enableTag(tag, enable != 0)
+	case "toggles":
+		keys, states := toggleStates()
+		for _, k := range keys {
+			m := newMessage(controlPrefix + "toggle")
+			m.Append(k, int32(boolInt(states[k])))
+			reply(m)
+		}
+	default:
+		slog.Warn("Unknown control command", slog.String("path", controlPrefix+cmd))
+	}
+}
+
+// reloadConfig replaces the running config with the config file. Servers
+// keep the addresses they were started with, and the events already queued
+// keep the mappings they were matched with.
+func reloadConfig() error {
+	if configPath == "" {
+		return errNoConfigFile
+	}
+	c, err := loadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	return swapConfig(c)
+}
+
+// configSource loads the running config again, from its file, preset or
+// the embedded config.
+var configSource func() (*Config, error)
+
+// swapConfig replaces the running config with c.
+func swapConfig(c *Config) error {
+	if err := checkDuplicates(c); err != nil {
+		return err
+	}
+	cfg := activeConfig.Load()
+	if !slices.Equal(c.MidiInputs, cfg.MidiInputs) {
+		slog.Warn("midi_inputs changed, restart to register the ports")
+	}
+	if c.clientName() != cfg.clientName() {
+		slog.Warn("client_name changed, restart to rename the client")
+	}
+	initVariables(c)
+	// Swapped between two JACK cycles, a MIDI message is always matched
+	// against a single config
+	cfgMu.Lock()
+	encoderPos = make([]int, len(c.Mappings))
+	activeConfig.Store(c)
+	cfgMu.Unlock()
+	closeScripts()
+	resetThrottles()
+	resetRamps()
+	resetDeadzones()
+	resetGestures()
+	startTimers(c)
+	watch(c.files)
+	slog.Info("Reloaded config", slog.String("file", configPath), slog.Any("osc_target", c.OscTarget))
+	return nil
+}
+
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/fjammes/midi2osc/resources"
+	"gopkg.in/yaml.v3"
+)
+
+// Controller names the controls of a MIDI device, so mappings can use
+// control: fader_1 instead of cc: 0.
+type Controller struct {
+	Name     string                       `yaml:"name"`
+	Channel  *MidiNumber                  `yaml:"channel"` // of all the controls, unless they set one
+	Controls map[string]ControllerControl `yaml:"controls"`
+	Identity *SysExPattern                `yaml:"identity"` // Identity Reply of the device, for controller: auto
+}
+
+type ControllerControl struct {
+	CC      *MidiNumber `yaml:"cc"`
+	Note    *MidiNumber `yaml:"note"`
+	Channel *MidiNumber `yaml:"channel"`
+}
+
+// controllerFlag is the -controller flag, replacing the controller of the
+// config.
+var controllerFlag string
+
+// loadController loads a built-in controller definition by name, or a
+// definition file.
+func loadController(name string) (*Controller, error) {
+	b, err := resources.Controllers.ReadFile("controllers/" + name + ".yaml")
+	if errors.Is(err, fs.ErrNotExist) {
+		b, err = os.ReadFile(name)
+		if errors.Is(err, fs.ErrNotExist) && !strings.ContainsAny(name, "./") {
+			return nil, fmt.Errorf("unknown controller %q, built-in controllers: %s", name, strings.Join(builtinControllers(), ", "))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	var c Controller
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("controller %s: %w", name, err)
+	}
+	return &c, nil
+}
+
+func builtinControllers() []string {
+	entries, _ := resources.Controllers.ReadDir("controllers")
+	var names []string
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), path.Ext(e.Name())))
+	}
+	return names
+}
+
+// resolveControls sets the MIDI message of the mappings naming a control
+// of the controller.
+func (c *Config) resolveControls() error {
+	name := controllerFlag
+	if name == "" {
+		name = c.Controller
+	}
+	if name == controllerAuto {
+		// The controls stay inactive until the device is identified
+		if name = identifiedController(); name == "" {
+			return nil
+		}
+	}
+	var ctrl *Controller
+	for i := range c.Mappings {
+		m := &c.Mappings[i]
+		if m.Control == "" {
+			continue
+		}
+		if name == "" {
+			return fmt.Errorf("mapping %d: control %q without controller", i+1, m.Control)
+		}
+		if ctrl == nil {
+			var err error
+			if ctrl, err = loadController(name); err != nil {
+				return err
+			}
+		}
+		ctl, ok := ctrl.Controls[m.Control]
+		if !ok {
+			names := make([]string, 0, len(ctrl.Controls))
+			for n := range ctrl.Controls {
+				names = append(names, n)
+			}
+			slices.Sort(names)
+			if s := closest(m.Control, names); s != "" {
+				return fmt.Errorf("mapping %d: unknown control %q (did you mean %q?)", i+1, m.Control, s)
+			}
+			return fmt.Errorf("mapping %d: unknown control %q", i+1, m.Control)
+		}
+		if m.CC != nil || m.Note != nil {
+			return fmt.Errorf("mapping %d: control %q with cc or note", i+1, m.Control)
+		}
+		m.CC, m.Note = ctl.CC, ctl.Note
+		if m.Channel == nil {
+			m.Channel = ctl.Channel
+		}
+		if m.Channel == nil {
+			m.Channel = ctrl.Channel
+		}
+	}
+	return nil
+}
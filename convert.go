@@ -0,0 +1,358 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// runConvert implements "midi2osc convert <file>", printing the midi2osc
+// config equivalent to the mappings of another tool, by file extension:
+//
+//	.omm       osc2midi map
+//	.touchosc  TouchOSC (mk1) layout, controls with a MIDI mapping
+//	.json      Open Stage Control session, widgets targeting MIDI
+//
+// What cannot be converted is reported on stderr.
+func runConvert(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: midi2osc convert <file.omm|file.touchosc|file.json>")
+		return 2
+	}
+	path := args[0]
+	var c *Config
+	var err error
+	switch filepath.Ext(path) {
+	case ".omm":
+		c, err = convertOSC2MIDI(path)
+	case ".touchosc":
+		c, err = convertTouchOSC(path)
+	case ".json":
+		c, err = convertOpenStageControl(path)
+	default:
+		err = fmt.Errorf("unknown format, expected .omm, .touchosc or .json")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		return 1
+	}
+	fmt.Printf("# Converted from %s, check osc_target\n", filepath.Base(path))
+	if err := printConfig(os.Stdout, c); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// convertWarn reports what was not converted, line being 0 for the
+// formats not line based.
+func convertWarn(path string, line int, format string, args ...interface{}) {
+	if line > 0 {
+		path = fmt.Sprintf("%s:%d", path, line)
+	}
+	fmt.Fprintf(os.Stderr, "%s: skipped: %s\n", path, fmt.Sprintf(format, args...))
+}
+
+// ommRe matches an osc2midi rule: "/path types, args : function(params);"
+var ommRe = regexp.MustCompile(`^(\S+)\s+([a-zA-Z]*)\s*,([^:]*):\s*(\w+)\s*\((.*)\)\s*;?$`)
+
+// convertOSC2MIDI reads an osc2midi map. Its MIDI channels count from 0.
+func convertOSC2MIDI(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	c := &Config{OscTarget: TargetList{"127.0.0.1:9000"}}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		m := ommRe.FindStringSubmatch(line)
+		if m == nil {
+			convertWarn(path, n, "unrecognized rule")
+			continue
+		}
+		oscPath, types, fn := m[1], m[2], m[4]
+		var oscArgs []string
+		for _, a := range strings.Split(m[3], ",") {
+			if a = strings.TrimSpace(a); a != "" {
+				oscArgs = append(oscArgs, a)
+			}
+		}
+		if len(oscArgs) != 1 || len(types) != 1 {
+			convertWarn(path, n, "only messages with a single argument are supported")
+			continue
+		}
+		var params []string
+		for _, p := range strings.Split(m[5], ",") {
+			params = append(params, strings.TrimSpace(p))
+		}
+
+		var mp Mapping
+		var value string
+		switch {
+		case fn == "controlchange" && len(params) == 3:
+			mp.CC, value = ommConst(params[1]), "$midi"
+		case (fn == "noteon" || fn == "note") && len(params) >= 3:
+			mp.Note, value = ommConst(params[1]), "$velocity"
+		case fn == "pitchbend" && len(params) == 2:
+			mp.PitchBend, value = true, "$bend"
+		default:
+			convertWarn(path, n, "unsupported function %s", fn)
+			continue
+		}
+		ch := ommConst(params[0])
+		if ch == nil || *ch > 15 || !mp.PitchBend && mp.CC == nil && mp.Note == nil {
+			convertWarn(path, n, "channel and control must be constants")
+			continue
+		}
+		*ch++
+		mp.Channel = ch
+		if !mp.PitchBend {
+			rng, ok := ommScale(params[len(params)-1], oscArgs[0])
+			if !ok {
+				convertWarn(path, n, "unsupported value %q", params[len(params)-1])
+				continue
+			}
+			mp.Range = rng
+		}
+		mp.Actions = []OSCAction{{Path: oscPath, Type: types, Value: value}}
+		c.Mappings = append(c.Mappings, mp)
+	}
+	return c, sc.Err()
+}
+
+func ommConst(s string) *MidiNumber {
+	n, err := strconv.ParseUint(s, 10, 7)
+	if err != nil {
+		return nil
+	}
+	v := MidiNumber(n)
+	return &v
+}
+
+// ommScale returns the OSC range of a MIDI value written as arg, arg*k or
+// k*arg.
+func ommScale(expr, arg string) ([2]float64, bool) {
+	expr = strings.ReplaceAll(expr, " ", "")
+	if expr == arg {
+		return [2]float64{}, true
+	}
+	a, b, ok := strings.Cut(expr, "*")
+	if !ok {
+		return [2]float64{}, false
+	}
+	if b == arg {
+		a, b = b, a
+	}
+	k, err := strconv.ParseFloat(b, 64)
+	if a != arg || err != nil || k == 0 {
+		return [2]float64{}, false
+	}
+	return [2]float64{0, 127 / k}, true
+}
+
+type touchLayout struct {
+	Pages []struct {
+		Name     string         `xml:"name,attr"`
+		Controls []touchControl `xml:"control"`
+	} `xml:"tabpage"`
+}
+
+type touchControl struct {
+	Name   string  `xml:"name,attr"`
+	Type   string  `xml:"type,attr"`
+	OscCS  string  `xml:"osc_cs,attr"` // custom address
+	ScaleF float64 `xml:"scalef,attr"`
+	ScaleT float64 `xml:"scalet,attr"`
+	Midi   []struct {
+		Type    int   `xml:"type,attr"` // 0 control change, 1 note
+		Channel uint8 `xml:"channel,attr"`
+		Data1   uint8 `xml:"data1,attr"`
+	} `xml:"midi"`
+}
+
+// convertTouchOSC reads the index.xml of a TouchOSC layout, a zip file.
+func convertTouchOSC(path string) (*Config, error) {
+	z, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+	f, err := z.Open("index.xml")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var layout touchLayout
+	if err := xml.NewDecoder(f).Decode(&layout); err != nil {
+		return nil, err
+	}
+
+	c := &Config{OscTarget: TargetList{"127.0.0.1:9000"}}
+	for _, page := range layout.Pages {
+		for _, ctl := range page.Controls {
+			addr := touchText(ctl.OscCS)
+			if addr == "" {
+				addr = "/" + touchText(page.Name) + "/" + touchText(ctl.Name)
+			}
+			rng := [2]float64{ctl.ScaleF, ctl.ScaleT}
+			if rng == [2]float64{} {
+				rng = [2]float64{0, 1}
+			}
+			for _, midi := range ctl.Midi {
+				if strings.HasPrefix(ctl.Type, "xy") || midi.Type > 1 {
+					convertWarn(path, 0, "%s: unsupported %s control or MIDI type %d", addr, ctl.Type, midi.Type)
+					continue
+				}
+				ch, num := MidiNumber(midi.Channel), MidiNumber(midi.Data1)
+				m := Mapping{Channel: &ch, Range: rng}
+				value := "$midi"
+				if midi.Type == 0 {
+					m.CC = &num
+				} else {
+					m.Note, value = &num, "$velocity"
+				}
+				m.Actions = []OSCAction{{Path: addr, Type: "f", Value: value}}
+				c.Mappings = append(c.Mappings, m)
+			}
+		}
+	}
+	return c, nil
+}
+
+// touchText decodes the base64 names of TouchOSC layouts, older ones
+// having them in clear.
+func touchText(s string) string {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return s
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) {
+			return s
+		}
+	}
+	return string(b)
+}
+
+// convertOpenStageControl reads a session, converting the widgets sending
+// MIDI control changes, notes or pitch bends. The mappings send the same
+// message to Open Stage Control, updating the widget from the controller.
+func convertOpenStageControl(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var session interface{}
+	if err := json.Unmarshal(b, &session); err != nil {
+		return nil, err
+	}
+	c := &Config{OscTarget: TargetList{"127.0.0.1:8080"}}
+	oscWidgets(session, func(w map[string]interface{}) {
+		if !oscMidiTarget(w["target"]) {
+			return
+		}
+		id, _ := w["id"].(string)
+		addr, _ := w["address"].(string)
+		pre, _ := w["preArgs"].([]interface{})
+		var nums []MidiNumber
+		for _, p := range pre {
+			f, ok := p.(float64)
+			if !ok || f < 0 || f > 127 {
+				break
+			}
+			nums = append(nums, MidiNumber(f))
+		}
+
+		var m Mapping
+		value := "$midi"
+		switch {
+		case addr == "/control" && len(nums) == 2 && len(pre) == 2:
+			m.CC = &nums[1]
+		case addr == "/note" && len(nums) == 2 && len(pre) == 2:
+			m.Note, value = &nums[1], "$velocity"
+		case addr == "/pitch" && len(nums) == 1 && len(pre) == 1:
+			m.PitchBend = true
+		default:
+			convertWarn(path, 0, "widget %s: unsupported MIDI address %q %v", id, addr, pre)
+			return
+		}
+		if nums[0] < 1 || nums[0] > 16 {
+			convertWarn(path, 0, "widget %s: channel %d out of 1-16", id, nums[0])
+			return
+		}
+		m.Channel = &nums[0]
+		if r, ok := w["range"].(map[string]interface{}); ok && !m.PitchBend {
+			lo, ok1 := r["min"].(float64)
+			hi, ok2 := r["max"].(float64)
+			if ok1 && ok2 && (lo != 0 || hi != 127) {
+				m.Range = [2]float64{lo, hi}
+			}
+		}
+		args := []OSCArg{{Type: "i", Value: int(nums[0])}}
+		if !m.PitchBend {
+			args = append(args, OSCArg{Type: "i", Value: int(nums[1])})
+		}
+		typ := "f"
+		if m.PitchBend {
+			typ, value = "i", "$bend"
+			m.Range = [2]float64{0, 16383}
+		}
+		args = append(args, OSCArg{Type: typ, Value: value})
+		m.Name = id
+		m.Actions = []OSCAction{{Path: addr, Args: args}}
+		c.Mappings = append(c.Mappings, m)
+	})
+	return c, nil
+}
+
+// oscWidgets calls fn for each widget of a session, widgets being the
+// objects with a type and an id.
+func oscWidgets(v interface{}, fn func(map[string]interface{})) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		_, typed := v["type"].(string)
+		_, named := v["id"].(string)
+		if typed && named {
+			fn(v)
+		}
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			oscWidgets(v[k], fn)
+		}
+	case []interface{}:
+		for _, child := range v {
+			oscWidgets(child, fn)
+		}
+	}
+}
+
+func oscMidiTarget(target interface{}) bool {
+	switch t := target.(type) {
+	case string:
+		return strings.HasPrefix(t, "midi:")
+	case []interface{}:
+		for _, s := range t {
+			if s, ok := s.(string); ok && strings.HasPrefix(s, "midi:") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,38 @@
+package main
+
+import "sync"
+
+var (
+	deadzonesMu sync.Mutex
+	deadzones   = map[controlKey]int64{} // last value let through, by control
+)
+
+func resetDeadzones() {
+	deadzonesMu.Lock()
+	deadzones = map[controlKey]int64{}
+	deadzonesMu.Unlock()
+}
+
+// inDeadzone reports whether the value of an event is within the deadzone
+// of the last value let through, and must be ignored. The ends of the
+// range always get through so a fader can reach them.
+func (m *Mapping) inDeadzone(ev MidiEvent) bool {
+	if m.Deadzone <= 0 {
+		return false
+	}
+	full := ev.Max
+	if full == 0 {
+		full = 127
+	}
+	zone := int64(m.Deadzone) * full / 127
+
+	deadzonesMu.Lock()
+	defer deadzonesMu.Unlock()
+	k := ev.controlKey()
+	last, ok := deadzones[k]
+	if ok && abs(ev.Value-last) <= zone && (ev.Value == last || ev.Value != 0 && ev.Value != full) {
+		return true
+	}
+	deadzones[k] = ev.Value
+	return false
+}
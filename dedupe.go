@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"sync"
+)
+
+var (
+	lastSentMu sync.Mutex
+	lastSent   = map[string][]byte{} // encoded message by target and path
+)
+
+// skipDuplicates reports whether identical values should not be resent for
+// the mapping of ev.
+func skipDuplicates(ev MidiEvent) bool {
+	if ev.Mapping != nil && ev.Mapping.SkipDuplicates != nil {
+		return *ev.Mapping.SkipDuplicates
+	}
+	return activeConfig.Load().SkipDuplicates
+}
+
+// duplicate reports whether msg is identical to the last message sent to
+// the same path of target, and records it otherwise.
+func duplicate(target string, msg *message) bool {
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		return false
+	}
+	key := target + " " + msg.Address
+
+	lastSentMu.Lock()
+	defer lastSentMu.Unlock()
+	if bytes.Equal(lastSent[key], data) {
+		return true
+	}
+	lastSent[key] = data
+	return false
+}
+
+// forgetSent clears the last value of a path so that a failed send is
+// retried next time.
+func forgetSent(target string, msg *message) {
+	lastSentMu.Lock()
+	delete(lastSent, target+" "+msg.Address)
+	lastSentMu.Unlock()
+}
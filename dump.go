@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// dumpConfig prints the config as it runs, with includes, profiles,
+// generated mappings, macros, controls, environment variables and -set
+// overrides resolved.
+func dumpConfig(c *Config) error {
+	d := *c
+	d.Include, d.Generate, d.Macros, d.Controller = nil, nil, nil, ""
+	d.Mappings, d.Profiles = nil, nil
+	d.Profile = activeProfile()
+	for name := range c.Profiles {
+		if d.Profiles == nil {
+			d.Profiles = map[string][]Mapping{}
+		}
+		d.Profiles[name] = nil
+	}
+	for _, m := range c.Mappings {
+		m.Control = ""
+		if m.Device == webMIDIDevice {
+			m.Port = 0
+		} else {
+			m.Device = ""
+		}
+		if m.profile == "" {
+			d.Mappings = append(d.Mappings, m)
+		} else {
+			d.Profiles[m.profile] = append(d.Profiles[m.profile], m)
+		}
+	}
+
+	return printConfig(os.Stdout, &d)
+}
+
+// printConfig writes a config as YAML.
+func printConfig(w io.Writer, c *Config) error {
+	node, err := dumpNode(reflect.ValueOf(*c))
+	if err != nil {
+		return err
+	}
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(node); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// dumpNode encodes v omitting the unset fields of structs, the settings
+// whose zero value is the default.
+func dumpNode(v reflect.Value) (*yaml.Node, error) {
+	if _, ok := v.Interface().(yaml.Marshaler); ok {
+		return encodeNode(v.Interface())
+	}
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return encodeNode(nil)
+		}
+		return dumpNode(v.Elem())
+	case reflect.Struct:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		for i := range v.NumField() {
+			name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+			f := v.Field(i)
+			if name == "" || name == "-" || !v.Type().Field(i).IsExported() || f.IsZero() {
+				continue
+			}
+			val, err := dumpNode(f)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: name}, val)
+		}
+		return node, nil
+	case reflect.Slice, reflect.Array:
+		node := &yaml.Node{Kind: yaml.SequenceNode}
+		if v.Type().Elem().Kind() != reflect.Struct {
+			node.Style = yaml.FlowStyle
+		}
+		for i := range v.Len() {
+			val, err := dumpNode(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, val)
+		}
+		return node, nil
+	case reflect.Map:
+		node := &yaml.Node{Kind: yaml.MappingNode}
+		keys := v.MapKeys()
+		slices.SortFunc(keys, func(a, b reflect.Value) int { return strings.Compare(fmt.Sprint(a), fmt.Sprint(b)) })
+		for _, k := range keys {
+			key, err := encodeNode(k.Interface())
+			if err != nil {
+				return nil, err
+			}
+			val, err := dumpNode(v.MapIndex(k))
+			if err != nil {
+				return nil, err
+			}
+			node.Content = append(node.Content, key, val)
+		}
+		return node, nil
+	}
+	return encodeNode(v.Interface())
+}
+
+func encodeNode(v interface{}) (*yaml.Node, error) {
+	var node yaml.Node
+	err := node.Encode(v)
+	return &node, err
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"gopkg.in/yaml.v3"
+)
+
+// strictLoad turns the duplicate mapping warnings into errors, with
+// -strict.
+var strictLoad bool
+
+// setSources records the file and line of the mappings of a config file,
+// lines being unknown for the converted formats.
+func (c *Config) setSources(name string, b []byte, lines bool) {
+	for i := range c.Mappings {
+		c.Mappings[i].source = name
+	}
+	for _, ms := range c.Profiles {
+		for i := range ms {
+			ms[i].source = name
+		}
+	}
+	for i := range c.Generate {
+		c.Generate[i].source = name
+	}
+	var doc yaml.Node
+	if !lines || yaml.Unmarshal(b, &doc) != nil || len(doc.Content) == 0 {
+		return
+	}
+	root := doc.Content[0]
+	at := func(n *yaml.Node) string { return fmt.Sprintf("%s:%d", name, n.Line) }
+	if n := mapValue(root, "mappings"); n != nil {
+		for i, item := range n.Content {
+			if i < len(c.Mappings) {
+				c.Mappings[i].source = at(item)
+			}
+		}
+	}
+	if n := mapValue(root, "profiles"); n != nil && n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			ms := c.Profiles[n.Content[i].Value]
+			for j, item := range n.Content[i+1].Content {
+				if j < len(ms) {
+					ms[j].source = at(item)
+				}
+			}
+		}
+	}
+	if n := mapValue(root, "generate"); n != nil {
+		for i, item := range n.Content {
+			if i < len(c.Generate) {
+				c.Generate[i].source = at(item)
+			}
+		}
+	}
+}
+
+// checkDuplicates warns about the mappings firing on the same control and
+// value as a previous one, and with -strict fails.
+func checkDuplicates(c *Config) error {
+	seen := map[string]*Mapping{}
+	for i := range c.Mappings {
+		m := &c.Mappings[i]
+		if m.CC == nil && m.Note == nil || m.When != nil {
+			continue
+		}
+		k := duplicateKey(m)
+		first, ok := seen[k]
+		if !ok {
+			seen[k] = m
+			continue
+		}
+		if strictLoad {
+			return fmt.Errorf("%s: same control and value as the mapping at %s", m.where(i), first.where(-1))
+		}
+		// Not first.stops(), which reads the running config and not c
+		stops := c.Match == "first"
+		if first.Stop != nil {
+			stops = *first.Stop
+		}
+		msg := "Duplicate mapping, both fire"
+		if stops {
+			msg = "Duplicate mapping never fires, the first one stops matching"
+		}
+		slog.Warn(msg, slog.String("mapping", m.where(i)), slog.String("first", first.where(-1)))
+	}
+	return nil
+}
+
+// where locates a mapping for messages, by index i when its source is not
+// known.
+func (m *Mapping) where(i int) string {
+	if m.source != "" {
+		return m.source
+	}
+	if i < 0 {
+		return m.key()
+	}
+	return fmt.Sprintf("mapping %d", i+1)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestCheckDuplicates(t *testing.T) {
+	tests := []struct {
+		name     string
+		mappings string
+		dup      bool
+	}{
+		{name: "same cc", mappings: "[{cc: 64}, {cc: 64}]", dup: true},
+		{name: "same cc and value", mappings: "[{cc: 64, value: 127}, {cc: 64, value: 127}]", dup: true},
+		{name: "other channel", mappings: "[{cc: 64, channel: 1}, {cc: 64, channel: 2}]"},
+		{name: "value split", mappings: "[{cc: 64, value: 0-63}, {cc: 64, value: 64-127}]"},
+		{name: "value_min and value_max split", mappings: "[{cc: 64, value_max: 63}, {cc: 64, value_min: 64}]"},
+		{name: "same value_min", mappings: "[{cc: 64, value_min: 64}, {cc: 64, value_min: 64}]", dup: true},
+		{name: "edge triggers", mappings: "[{cc: 64, threshold: 64}, {cc: 64, threshold: 64, edge: falling}]"},
+		{name: "cc14", mappings: "[{cc: 1}, {cc: 1, cc14: true}]"},
+		{name: "poly aftertouch", mappings: "[{note: 60}, {note: 60, poly_aftertouch: true}]"},
+		{name: "shift layer", mappings: "[{cc: 1}, {cc: 1, when: {note: 0}}]"},
+	}
+	defer func(s bool) { strictLoad = s }(strictLoad)
+	strictLoad = true
+	for _, tt := range tests {
+		var c Config
+		if err := yaml.Unmarshal([]byte("mappings: "+tt.mappings), &c); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if err := checkDuplicates(&c); (err != nil) != tt.dup {
+			t.Errorf("%s: checkDuplicates = %v, want a duplicate %t", tt.name, err, tt.dup)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hypebeast/go-osc/osc"
+)
+
+const defaultEchoWindow = 200 * time.Millisecond
+
+// sentValue is a value we sent out, remembered to recognize its echo.
+type sentValue struct {
+	value string
+	at    time.Time
+}
+
+// With feedback enabled, values go both ways and a receiver echoing what
+// it got would bounce it back forever. What we send is remembered for a
+// short window, and the same value coming back in that window is ignored.
+var (
+	echoMu     sync.Mutex
+	oscEchoes  = map[string]sentValue{}  // by OSC address
+	midiEchoes = map[[2]byte]sentValue{} // by status and data1
+)
+
+func echoWindow() time.Duration {
+	cfg := activeConfig.Load()
+	if cfg == nil || cfg.Feedback == nil {
+		return 0
+	}
+	if cfg.Feedback.EchoWindowMs == 0 {
+		return defaultEchoWindow
+	}
+	return time.Duration(cfg.Feedback.EchoWindowMs) * time.Millisecond
+}
+
+// recordOSCEcho remembers an OSC message sent to a target.
+func recordOSCEcho(msg *message) {
+	if echoWindow() <= 0 {
+		return
+	}
+	echoMu.Lock()
+	oscEchoes[msg.Address] = sentValue{fmt.Sprint(msg.Arguments), time.Now()}
+	echoMu.Unlock()
+}
+
+// isOSCEcho reports whether a received OSC message repeats one we just sent.
+func isOSCEcho(msg *osc.Message) bool {
+	window := echoWindow()
+	if window <= 0 {
+		return false
+	}
+	echoMu.Lock()
+	sent, ok := oscEchoes[msg.Address]
+	echoMu.Unlock()
+	return ok && time.Since(sent.at) < window && sent.value == fmt.Sprint(msg.Arguments)
+}
+
+// recordMidiEcho remembers a channel message sent on midi_out.
+func recordMidiEcho(msg []byte) {
+	if len(msg) != 3 || echoWindow() <= 0 {
+		return
+	}
+	echoMu.Lock()
+	midiEchoes[[2]byte{msg[0], msg[1]}] = sentValue{string(msg[2:]), time.Now()}
+	echoMu.Unlock()
+}
+
+// isMidiEcho reports whether a received MIDI message repeats one we just
+// sent, such as a motorized fader reporting the position it was moved to.
+func isMidiEcho(msg []byte) bool {
+	if len(msg) != 3 {
+		return false
+	}
+	window := echoWindow()
+	if window <= 0 {
+		return false
+	}
+	echoMu.Lock()
+	sent, ok := midiEchoes[[2]byte{msg[0], msg[1]}]
+	echoMu.Unlock()
+	return ok && time.Since(sent.at) < window && sent.value == string(msg[2:])
+}
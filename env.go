@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// envRe matches ${NAME} and ${NAME:-default} references. The $ placeholders
+// such as $midi have no braces.
+var envRe = regexp.MustCompile(`\$\{(\w+)(:-[^}]*)?\}`)
+
+// expandEnv replaces the environment variable references of the targets
+// and action values, so a config works on several machines. The MQTT
+// credentials and the HTTP tokens may be kept out of the config this way.
+func (c *Config) expandEnv() error {
+	var err error
+	str := func(s string) string {
+		return envRe.ReplaceAllStringFunc(s, func(ref string) string {
+			m := envRe.FindStringSubmatch(ref)
+			if v, ok := os.LookupEnv(m[1]); ok {
+				return v
+			}
+			if m[2] == "" && err == nil {
+				err = fmt.Errorf("environment variable %s not set", m[1])
+			}
+			return strings.TrimPrefix(m[2], ":-")
+		})
+	}
+	targets := func(t TargetList) {
+		for i := range t {
+			t[i] = str(t[i])
+		}
+	}
+	value := func(v interface{}) interface{} {
+		if s, ok := v.(string); ok {
+			return str(s)
+		}
+		return v
+	}
+	actions := func(acts []OSCAction) {
+		for i := range acts {
+			a := &acts[i]
+			a.Path = str(a.Path)
+			a.Value = value(a.Value)
+			a.OscTarget = append(TargetList(nil), a.OscTarget...) // shared by macro copies
+			targets(a.OscTarget)
+			a.Args = append([]OSCArg(nil), a.Args...)
+			for j := range a.Args {
+				a.Args[j].Value = value(a.Args[j].Value)
+			}
+			if a.HTTP != nil {
+				h := *a.HTTP
+				h.URL = str(h.URL)
+				h.Headers = make(map[string]string, len(a.HTTP.Headers))
+				for k, v := range a.HTTP.Headers {
+					h.Headers[k] = str(v)
+				}
+				a.HTTP = &h
+			}
+		}
+	}
+
+	targets(c.OscTarget)
+	if c.MQTT != nil {
+		c.MQTT.Broker = str(c.MQTT.Broker)
+		c.MQTT.Username = str(c.MQTT.Username)
+		c.MQTT.Password = str(c.MQTT.Password)
+	}
+	for i := range c.Mappings {
+		m := &c.Mappings[i]
+		targets(m.OscTarget)
+		for _, acts := range [][]OSCAction{m.Actions, m.OffActions, m.OnTap, m.OnDoubleTap, m.OnHold} {
+			actions(acts)
+		}
+	}
+	actions(c.OnStart)
+	actions(c.OnExit)
+	for i := range c.Timers {
+		targets(c.Timers[i].OscTarget)
+		actions(c.Timers[i].Actions)
+	}
+	if len(c.Targets) > 0 {
+		opts := make(map[string]TargetOptions, len(c.Targets))
+		for t, o := range c.Targets {
+			opts[str(t)] = o
+		}
+		c.Targets = opts
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultExecTimeout = 10 * time.Second
+
+// run starts the command of an action triggered by ev, without waiting for
+// it. The arguments are templates, and the event is also passed in the
+// MIDI2OSC_* environment variables for shell scripts.
+func (ea *ExecAction) run(ev MidiEvent) {
+	if len(ea.Command) == 0 {
+		slog.Error("exec action without command", ev.mappingAttr())
+		return
+	}
+	args := make([]string, len(ea.Command))
+	for i, a := range ea.Command {
+		args[i] = expandTemplate(a, ev)
+	}
+	env := append(os.Environ(),
+		"MIDI2OSC_VALUE="+strconv.FormatInt(ev.Value, 10),
+		"MIDI2OSC_CHANNEL="+strconv.Itoa(int(ev.Channel)),
+		"MIDI2OSC_CC="+strconv.Itoa(int(ev.CC)),
+		"MIDI2OSC_NOTE="+strconv.Itoa(int(ev.Note)),
+		"MIDI2OSC_VELOCITY="+strconv.Itoa(int(ev.Velocity)),
+	)
+	timeout := defaultExecTimeout
+	if ea.TimeoutMs > 0 {
+		timeout = time.Duration(ea.TimeoutMs) * time.Millisecond
+	}
+	attr := ev.mappingAttr()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Env = env
+		start := time.Now()
+		out, err := cmd.CombinedOutput()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		if err != nil {
+			slog.Error("Command failed", attr, slog.Any("command", args), slog.Any("err", err), slog.String("output", strings.TrimSpace(string(out))))
+			return
+		}
+		slog.Info("Command run", attr, slog.Any("command", args), slog.Duration("duration", time.Since(start)))
+		if len(out) > 0 {
+			slog.Debug("Command output", attr, slog.String("output", strings.TrimSpace(string(out))))
+		}
+	}()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"sync"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// Compiled expressions by source. Expressions are only evaluated by the
+// sender goroutine, the lock is for the retry and feedback goroutines.
+var (
+	exprCacheMu sync.Mutex
+	exprCache   = map[string]*vm.Program{}
+)
+
+// exprFuncs are the math helpers available in expressions.
+var exprFuncs = map[string]interface{}{
+	"pow":   math.Pow,
+	"sqrt":  math.Sqrt,
+	"exp":   math.Exp,
+	"log":   math.Log,
+	"log10": math.Log10,
+	"sin":   math.Sin,
+	"cos":   math.Cos,
+	"clamp": func(x, lo, hi float64) float64 { return math.Max(lo, math.Min(hi, x)) },
+}
+
+// isExpression reports whether the value of a numeric argument is an
+// expression such as "pow(v/127, 2) * 60 - 60" rather than a number.
+func isExpression(val interface{}) bool {
+	s, ok := val.(string)
+	if !ok || s == "" {
+		return false
+	}
+	_, err := strconv.ParseFloat(s, 64)
+	return err != nil
+}
+
+// evalExpression computes an expression with the event data: v is the MIDI
+// value and max its full scale, along with channel, cc, note, velocity,
+// bend, pressure and the shared variables in var.
+func evalExpression(src string, ev MidiEvent) (interface{}, error) {
+	exprCacheMu.Lock()
+	prog, ok := exprCache[src]
+	exprCacheMu.Unlock()
+	if !ok {
+		var err error
+		prog, err = expr.Compile(src, expr.Env(exprEnv(MidiEvent{})))
+		if err != nil {
+			return nil, err
+		}
+		exprCacheMu.Lock()
+		exprCache[src] = prog
+		exprCacheMu.Unlock()
+	}
+	return expr.Run(prog, exprEnv(ev))
+}
+
+func exprEnv(ev MidiEvent) map[string]interface{} {
+	max := ev.Max
+	if max == 0 {
+		max = 127
+	}
+	env := map[string]interface{}{
+		"v":        float64(ev.Value),
+		"max":      float64(max),
+		"channel":  int(ev.Channel),
+		"cc":       int(ev.CC),
+		"note":     int(ev.Note),
+		"velocity": int(ev.Velocity),
+		"bend":     ev.Bend,
+		"pressure": int(ev.Pressure),
+		"var":      variables(),
+	}
+	for name, f := range exprFuncs {
+		env[name] = f
+	}
+	return env
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"path"
+
+	"github.com/hypebeast/go-osc/osc"
+)
+
+// startFeedback listens for OSC messages on UDP and turns the ones matching
+// a feedback mapping into MIDI output. The remote control API is served on
+// the same socket.
+func startFeedback(fb *Feedback) error {
+	conn, err := net.ListenPacket("udp", fb.Listen)
+	if err != nil {
+		return err
+	}
+	slog.Info("OSC feedback server listening", slog.String("addr", conn.LocalAddr().String()))
+	go serveFeedback(conn)
+	return nil
+}
+
+func serveFeedback(conn net.PacketConn) {
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			slog.Error("OSC feedback server stopped", slog.Any("err", err))
+			return
+		}
+		packet, err := osc.ParsePacket(string(buf[:n]))
+		if err != nil {
+			slog.Warn("Invalid OSC packet received", slog.String("from", from.String()), slog.Any("err", err))
+			continue
+		}
+		reply := func(m *message) {
+			if data, err := m.MarshalBinary(); err == nil {
+				conn.WriteTo(data, from)
+			}
+		}
+		walkPacket(packet, func(m *osc.Message) { handleOSC(m, reply) })
+	}
+}
+
+// walkPacket calls fn for every message of a packet, including the ones
+// nested in bundles.
+func walkPacket(packet osc.Packet, fn func(*osc.Message)) {
+	switch p := packet.(type) {
+	case *osc.Message:
+		fn(p)
+	case *osc.Bundle:
+		for _, m := range p.Messages {
+			fn(m)
+		}
+		for _, b := range p.Bundles {
+			walkPacket(b, fn)
+		}
+	}
+}
+
+func (fb *Feedback) handle(msg *osc.Message) {
+	if isOSCEcho(msg) {
+		slog.Debug("OSC echo ignored", slog.String("path", msg.Address))
+		return
+	}
+	publishOSC(&message{*msg})
+	for i := range fb.Mappings {
+		fm := &fb.Mappings[i]
+		if ok, _ := path.Match(fm.Path, msg.Address); !ok {
+			continue
+		}
+		if fm.Arg < 0 || fm.Arg >= len(msg.Arguments) {
+			slog.Warn("OSC feedback argument missing", slog.String("path", msg.Address), slog.Int("arg", fm.Arg))
+			continue
+		}
+		val, ok := oscNumber(msg.Arguments[fm.Arg])
+		if !ok {
+			slog.Warn("OSC feedback argument is not a number", slog.String("path", msg.Address), slog.Any("val", msg.Arguments[fm.Arg]))
+			continue
+		}
+		if fm.Led != nil {
+			fm.Led.send(val)
+			continue
+		}
+		if out := fm.midi(val); out != nil {
+			slog.Debug("OSC feedback", slog.String("path", msg.Address), slog.Float64("val", val), slog.String("midi", fmt.Sprintf("% X", out)))
+			recordMidiEcho(out)
+			sendMidi(out)
+			if fm.CC != nil {
+				setControlTarget(out[0]&0x0F+1, out[1], out[2])
+			}
+		}
+	}
+}
+
+// midi returns the MIDI message a feedback value is sent as.
+func (fm *FeedbackMapping) midi(val float64) []byte {
+	if fm.Range != [2]float64{} && fm.Range[1] != fm.Range[0] {
+		val = (val - fm.Range[0]) / (fm.Range[1] - fm.Range[0]) * 127
+	}
+	data := byte(math.Round(min(max(val, 0), 127)))
+
+	channel := byte(0)
+	if fm.Channel != nil && *fm.Channel >= 1 && *fm.Channel <= 16 {
+		channel = byte(*fm.Channel) - 1
+	}
+	switch {
+	case fm.CC != nil:
+		return []byte{0xB0 | channel, byte(*fm.CC) & 0x7F, data}
+	case fm.Note != nil:
+		return []byte{0x90 | channel, byte(*fm.Note) & 0x7F, data}
+	}
+	return nil
+}
+
+// oscNumber converts a numeric or boolean OSC argument to a float.
+func oscNumber(arg interface{}) (float64, bool) {
+	switch v := arg.(type) {
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float32:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
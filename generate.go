@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/expr-lang/expr"
+	"gopkg.in/yaml.v3"
+)
+
+// Generator expands a mapping template over a range, e.g. for a bank of
+// faders:
+//
+//	generate:
+//	  - range: [1, 8]
+//	    mapping:
+//	      cc: "{{i + 19}}"
+//	      actions: [{path: "/strip/{{i}}/fader", type: f, value: $midi}]
+//
+// Placeholders using the index are replaced at load time, the others such
+// as {{value}} being left for the events.
+type Generator struct {
+	Range   [2]int    `yaml:"range"` // first and last index
+	Var     string    `yaml:"var"`   // index name, "i" when unset
+	Mapping yaml.Node `yaml:"mapping"`
+
+	source string // file:line of the generator, source of its mappings
+}
+
+// generateMappings appends the mappings of the generators.
+func (c *Config) generateMappings() error {
+	for n, g := range c.Generate {
+		if g.Mapping.Kind == 0 {
+			return fmt.Errorf("generate %d: missing mapping", n+1)
+		}
+		name := g.Var
+		if name == "" {
+			name = "i"
+		}
+		tmpl, err := yaml.Marshal(&g.Mapping)
+		if err != nil {
+			return err
+		}
+		step := 1
+		if g.Range[1] < g.Range[0] {
+			step = -1
+		}
+		for i := g.Range[0]; ; i += step {
+			m, err := instantiate(tmpl, name, i)
+			if err != nil {
+				return fmt.Errorf("generate %d, %s=%d: %w", n+1, name, i, err)
+			}
+			m.source = g.source
+			c.Mappings = append(c.Mappings, *m)
+			if i == g.Range[1] {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// instantiate decodes a mapping template with the index name set to i.
+func instantiate(tmpl []byte, name string, i int) (*Mapping, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(tmpl, &node); err != nil {
+		return nil, err
+	}
+	env := map[string]interface{}{name: float64(i)} // float for the math functions
+	for f, fn := range exprFuncs {
+		env[f] = fn
+	}
+	if err := substitute(&node, env); err != nil {
+		return nil, err
+	}
+	b, err := yaml.Marshal(&node)
+	if err != nil {
+		return nil, err
+	}
+	var m Mapping
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&m); err != nil {
+		return nil, suggestFields(err)
+	}
+	return &m, nil
+}
+
+// substitute replaces the {{expr}} placeholders of the scalars of node
+// that only use env.
+func substitute(node *yaml.Node, env map[string]interface{}) error {
+	if node.Kind != yaml.ScalarNode {
+		for _, n := range node.Content {
+			if err := substitute(n, env); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	s := node.Value
+	var b strings.Builder
+	whole := false
+	for {
+		start := strings.Index(s, "{{")
+		end := strings.Index(s[max(start, 0):], "}}")
+		if start < 0 || end < 0 {
+			break
+		}
+		end += start
+		src := s[start+2 : end]
+		prog, err := expr.Compile(src, expr.Env(env))
+		if err != nil {
+			// Not about the index, an event placeholder
+			b.WriteString(s[:end+2])
+			s = s[end+2:]
+			continue
+		}
+		v, err := expr.Run(prog, env)
+		if err != nil {
+			return fmt.Errorf("%q: %w", src, err)
+		}
+		whole = start == 0 && end+2 == len(s) && b.Len() == 0
+		b.WriteString(s[:start])
+		fmt.Fprint(&b, v)
+		s = s[end+2:]
+	}
+	b.WriteString(s)
+	if b.String() != node.Value {
+		node.Value = b.String()
+		if whole {
+			// A number for fields such as cc, even if the template was quoted
+			node.Tag, node.Style = "", 0
+		}
+	}
+	return nil
+}
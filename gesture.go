@@ -0,0 +1,131 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultHold      = 500 * time.Millisecond
+	defaultDoubleTap = 300 * time.Millisecond
+)
+
+type gestureState struct {
+	pressed bool
+	press   MidiEvent   // event of the last press, sent with the gesture
+	taps    int         // releases waiting for a possible second tap
+	held    bool        // the current press already fired on_hold
+	timer   *time.Timer // hold timer while pressed, double tap timer after a release
+}
+
+var (
+	gesturesMu sync.Mutex
+	gestures   = map[controlKey]*gestureState{} // by control, see throttle
+)
+
+// resetGestures forgets the buttons held and the gestures pending, whose
+// mappings are gone after a reload.
+func resetGestures() {
+	gesturesMu.Lock()
+	defer gesturesMu.Unlock()
+	for _, st := range gestures {
+		if st.timer != nil {
+			st.timer.Stop()
+		}
+	}
+	gestures = map[controlKey]*gestureState{}
+}
+
+func (m *Mapping) hasGestures() bool {
+	return m.OnTap != nil || m.OnDoubleTap != nil || m.OnHold != nil
+}
+
+// gesture tracks the presses (non-zero value) and releases of a button and
+// dispatches the actions of the gesture once it is recognized. A tap waits
+// for the double tap delay only when the mapping has on_double_tap.
+func (m *Mapping) gesture(ev MidiEvent) {
+	gesturesMu.Lock()
+	defer gesturesMu.Unlock()
+	k := ev.controlKey()
+	st, ok := gestures[k]
+	if !ok {
+		st = &gestureState{}
+		gestures[k] = st
+	}
+
+	if ev.Value > 0 {
+		if st.pressed {
+			return
+		}
+		st.pressed, st.held, st.press = true, false, ev
+		if st.timer != nil {
+			st.timer.Stop() // second tap before the double tap delay
+		}
+		if m.OnHold != nil {
+			st.timer = time.AfterFunc(m.holdDelay(), func() {
+				gesturesMu.Lock()
+				defer gesturesMu.Unlock()
+				if st.pressed {
+					st.held, st.taps = true, 0
+					fire(ev, m.OnHold)
+				}
+			})
+		}
+		return
+	}
+
+	if !st.pressed {
+		return
+	}
+	st.pressed = false
+	if st.timer != nil {
+		st.timer.Stop()
+	}
+	if st.held {
+		return
+	}
+	st.taps++
+	switch {
+	case m.OnDoubleTap == nil:
+		st.taps = 0
+		fire(st.press, m.OnTap)
+	case st.taps == 2:
+		st.taps = 0
+		fire(st.press, m.OnDoubleTap)
+	default:
+		st.timer = time.AfterFunc(m.doubleTapDelay(), func() {
+			gesturesMu.Lock()
+			defer gesturesMu.Unlock()
+			if !st.pressed && st.taps == 1 {
+				st.taps = 0
+				fire(st.press, m.OnTap)
+			}
+		})
+	}
+}
+
+// fire queues the actions of a recognized gesture.
+func fire(ev MidiEvent, actions []OSCAction) {
+	if len(actions) == 0 {
+		return
+	}
+	ev.Actions = actions
+	select {
+	case eventChan <- ev:
+	default:
+	}
+}
+
+func (m *Mapping) holdDelay() time.Duration {
+	if m.HoldMs <= 0 {
+		return defaultHold
+	}
+	return time.Duration(m.HoldMs) * time.Millisecond
+}
+
+func (m *Mapping) doubleTapDelay() time.Duration {
+	if m.DoubleTapMs <= 0 {
+		return defaultDoubleTap
+	}
+	return time.Duration(m.DoubleTapMs) * time.Millisecond
+}
@@ -3,7 +3,25 @@ module github.com/fjammes/midi2osc
 go 1.23.4
 
 require (
+	github.com/eclipse/paho.mqtt.golang v1.4.3
+	github.com/expr-lang/expr v1.17.8
+	github.com/fsnotify/fsnotify v1.7.0
+	github.com/godbus/dbus/v5 v5.1.0
+	github.com/grandcat/zeroconf v1.0.0
 	github.com/hypebeast/go-osc v0.0.0-20220308234300-cec5a8a1e5f5
+	github.com/pelletier/go-toml/v2 v2.4.3
 	github.com/xthexder/go-jack v0.0.0-20220805234212-bc8604043aba
+	github.com/yuin/gopher-lua v1.1.1
+	gitlab.com/gomidi/midi/v2 v2.2.19
+	golang.org/x/net v0.8.0
+	golang.org/x/sys v0.6.0
 	gopkg.in/yaml.v3 v3.0.1
 )
+
+require (
+	github.com/cenkalti/backoff v2.2.1+incompatible // indirect
+	github.com/gorilla/websocket v1.5.0 // indirect
+	github.com/miekg/dns v1.1.27 // indirect
+	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550 // indirect
+	golang.org/x/sync v0.1.0 // indirect
+)
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+)
+
+// identityRequest asks every device for its Identity Reply.
+var identityRequest = []byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}
+
+// controllerAuto as controller selects the built-in controller whose
+// identity matches the device answering the Identity Request.
+const controllerAuto = "auto"
+
+// identified is the built-in controller matching the last Identity Reply.
+var identified atomic.Value
+
+func identifiedController() string {
+	name, _ := identified.Load().(string)
+	return name
+}
+
+// requestIdentity sends an Identity Request, on connection of the input.
+func requestIdentity() {
+	sendMidi(identityRequest)
+}
+
+// isIdentityReply reports whether msg is an Identity Reply, of any device.
+func isIdentityReply(msg []byte) bool {
+	return len(msg) >= 15 && msg[0] == 0xF0 && msg[1] == 0x7E && msg[3] == 0x06 && msg[4] == 0x02
+}
+
+// identityReplies passes the Identity Replies from the input thread to
+// identifyDevices.
+var identityReplies = make(chan []byte, 4)
+
+func identifyDevices() {
+	for msg := range identityReplies {
+		identify(msg)
+	}
+}
+
+// identify logs the device of an Identity Reply and, with controller:
+// auto, loads the config again with the matching controller. It loads
+// files, so it runs out of the JACK thread.
+func identify(msg []byte) {
+	mfr, rest := msg[5:6], msg[6:]
+	if msg[5] == 0 { // extended manufacturer id
+		mfr, rest = msg[5:8], msg[8:]
+	}
+	if len(rest) < 9 {
+		return
+	}
+	var name string
+	for _, n := range builtinControllers() {
+		if c, err := loadController(n); err == nil && c.Identity != nil && c.Identity.match(msg) {
+			name = n
+			break
+		}
+	}
+	slog.Info("MIDI device identified",
+		slog.String("manufacturer", fmt.Sprintf("% X", mfr)),
+		slog.String("family", fmt.Sprintf("%04X", int(rest[0])|int(rest[1])<<8)),
+		slog.String("model", fmt.Sprintf("%04X", int(rest[2])|int(rest[3])<<8)),
+		slog.String("version", fmt.Sprintf("% X", rest[4:8])),
+		slog.String("controller", name))
+
+	if name == "" || name == identifiedController() {
+		return
+	}
+	identified.Store(name)
+	auto := controllerFlag == controllerAuto || controllerFlag == "" && activeConfig.Load().Controller == controllerAuto
+	if !auto {
+		return
+	}
+	c, err := configSource()
+	if err == nil {
+		err = swapConfig(c)
+	}
+	if err != nil {
+		slog.Error("Failed to load the config for the identified controller", slog.String("controller", name), slog.Any("err", err))
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfig loads a YAML, JSON or TOML config from a file, stdin or a URL,
+// or all the config files of a directory in name order, and merges the
+// files they include.
+func loadConfig(path string) (*Config, error) {
+	c, err := loadFiles(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := c.resolve(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadFiles decodes path and its includes, stack holding the files being
+// loaded to detect include cycles.
+func loadFiles(path string, stack []string) (*Config, error) {
+	local := path != "-" && !isURL(path)
+	abs := path
+	if local {
+		var err error
+		if abs, err = filepath.Abs(path); err != nil {
+			return nil, err
+		}
+	}
+	if slices.Contains(stack, abs) {
+		return nil, fmt.Errorf("include cycle: %s", strings.Join(append(stack, abs), " -> "))
+	}
+	stack = append(stack, abs)
+
+	if local {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			return loadDir(path, stack)
+		}
+	}
+
+	b, err := readConfig(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	c, err := decodeConfig(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	c.setSources(path, b, filepath.Ext(path) != ".toml")
+	if local {
+		c.files = []string{abs}
+	}
+	for _, pattern := range c.Include {
+		matches, err := includeFiles(path, pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: include %q: %w", path, pattern, err)
+		}
+		for _, m := range matches {
+			inc, err := loadFiles(m, stack)
+			if err != nil {
+				return nil, err
+			}
+			c.merge(inc)
+		}
+	}
+	return c, nil
+}
+
+// includeFiles returns the files matching an include pattern of the
+// config at base. Includes of a config from a URL are relative to it.
+func includeFiles(base, pattern string) ([]string, error) {
+	if isURL(pattern) {
+		return []string{pattern}, nil
+	}
+	if isURL(base) && !filepath.IsAbs(pattern) {
+		u, err := url.Parse(base)
+		if err != nil {
+			return nil, err
+		}
+		ref, err := url.Parse(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return []string{u.ResolveReference(ref).String()}, nil
+	}
+	if !filepath.IsAbs(pattern) && base != "-" {
+		pattern = filepath.Join(filepath.Dir(base), pattern)
+	}
+	matches, err := filepath.Glob(pattern)
+	if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+		matches = []string{pattern} // reported as missing by loadFiles
+	}
+	return matches, err
+}
+
+// loadDir merges the config files of a directory.
+func loadDir(dir string, stack []string) (*Config, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{files: []string{stack[len(stack)-1]}}
+	for _, e := range entries { // sorted by name
+		if e.IsDir() || !isConfigExt(e.Name()) {
+			continue
+		}
+		f, err := loadFiles(filepath.Join(dir, e.Name()), stack)
+		if err != nil {
+			return nil, err
+		}
+		c.merge(f)
+	}
+	return c, nil
+}
+
+// fetchConfig downloads a config, on each load so reloads get the
+// latest version.
+func fetchConfig(url string) ([]byte, error) {
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func isConfigExt(name string) bool {
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml", ".json", ".toml":
+		return true
+	}
+	return false
+}
+
+// stdinConfig is the config read from stdin with -config -, read once
+// for the reloads.
+var stdinConfig = sync.OnceValues(func() ([]byte, error) {
+	return io.ReadAll(os.Stdin)
+})
+
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// readConfig reads a config file, stdin for "-" or a URL, converting TOML
+// to YAML. JSON needs no conversion, being YAML.
+func readConfig(path string) ([]byte, error) {
+	var b []byte
+	var err error
+	ext := filepath.Ext(path)
+	switch {
+	case path == "-":
+		b, err = stdinConfig()
+	case isURL(path):
+		b, err = fetchConfig(path)
+		if u, perr := url.Parse(path); perr == nil {
+			ext = filepath.Ext(u.Path)
+		}
+	default:
+		b, err = os.ReadFile(path)
+	}
+	if err != nil || ext != ".toml" {
+		return b, err
+	}
+	var v map[string]interface{}
+	if err := toml.Unmarshal(b, &v); err != nil {
+		var derr *toml.DecodeError
+		if errors.As(err, &derr) {
+			row, _ := derr.Position()
+			return nil, fmt.Errorf("line %d: %w", row, err)
+		}
+		return nil, err
+	}
+	return yaml.Marshal(v)
+}
+
+// merge adds the config of an included file: lists are appended, map
+// entries and settings are only taken when c does not define them.
+func (c *Config) merge(inc *Config) {
+	dst, src := reflect.ValueOf(c).Elem(), reflect.ValueOf(inc).Elem()
+	for i := range dst.NumField() {
+		d, s := dst.Field(i), src.Field(i)
+		if !d.CanSet() || s.IsZero() {
+			continue
+		}
+		switch {
+		case d.Type() == reflect.TypeOf(TargetList{}):
+			// A single destination, not a list to append to
+			if d.Len() == 0 {
+				d.Set(s)
+			}
+		case d.Kind() == reflect.Slice:
+			d.Set(reflect.AppendSlice(d, s))
+		case d.Kind() == reflect.Map:
+			if d.IsNil() {
+				d.Set(reflect.MakeMap(d.Type()))
+			}
+			for _, k := range s.MapKeys() {
+				if !d.MapIndex(k).IsValid() {
+					d.SetMapIndex(k, s.MapIndex(k))
+				}
+			}
+		case d.IsZero():
+			d.Set(s)
+		}
+	}
+	c.files = append(c.files, inc.files...)
+}
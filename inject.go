@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// injector feeds the mappings with MIDI messages written in hex, one or
+// more per line such as "B0 14 7F", from stdin or a named pipe. Lines
+// starting with # are comments. Stdin ends the program at its end, a pipe
+// is opened again for the next writer.
+type injector struct {
+	path   string
+	parser midiParser
+}
+
+func openInjector(path string) (*injector, error) {
+	if path == "-" && configPath == "-" {
+		return nil, errors.New("stdin is already the config")
+	}
+	if path != "-" {
+		if _, err := os.Stat(path); err != nil {
+			return nil, err
+		}
+	}
+	return &injector{path: path}, nil
+}
+
+func (j *injector) start() error {
+	go discardMidiOut()
+	go j.run()
+	return nil
+}
+
+func (j *injector) close() {}
+
+func (j *injector) run() {
+	if j.path == "-" {
+		j.read(os.Stdin)
+		flushEvents()
+		close(inputEnded)
+		return
+	}
+	for {
+		// Blocks until a writer opens the pipe
+		f, err := os.Open(j.path)
+		if err != nil {
+			slog.Error("Failed to open MIDI injection pipe", slog.String("path", j.path), slog.Any("err", err))
+			return
+		}
+		j.read(f)
+		f.Close()
+	}
+}
+
+func (j *injector) read(r io.Reader) {
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		msg, err := parseHex(line)
+		if err != nil {
+			slog.Warn("Invalid injected MIDI", slog.String("source", j.path), slog.Int("line", n), slog.Any("err", err))
+			continue
+		}
+		// In order, each message being queued before the next
+		waitEvents(0)
+		cfgMu.RLock()
+		if activeConfig.Load() != nil {
+			j.parser.feed(msg, handleMessage)
+		}
+		cfgMu.RUnlock()
+	}
+	if err := sc.Err(); err != nil {
+		slog.Error("Failed to read injected MIDI", slog.String("source", j.path), slog.Any("err", err))
+	}
+}
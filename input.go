@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+)
+
+// midiInput is a MIDI backend. Once started it maps the received messages
+// with handleMessage, holding cfgMu, and sends the messages queued with
+// sendMidi.
+type midiInput interface {
+	start() error
+	close()
+}
+
+// realtimeInput is a backend mapping from a real-time thread, where the
+// remote messages are mapped too, with handleRemote.
+type realtimeInput interface {
+	midiInput
+	realtime()
+}
+
+// inputs opens the backends built in by -input name, registered by their
+// files. Some need build tags, see the files.
+var inputs = map[string]func(name string) (midiInput, error){}
+
+// inputPort selects the ports of the backends opening existing ones.
+var inputPort string
+
+// defaultInput is JACK when built in.
+func defaultInput() string {
+	if _, ok := inputs["jack"]; ok || len(inputs) == 0 {
+		return "jack"
+	}
+	return inputNames()[0]
+}
+
+func inputNames() []string {
+	var names []string
+	for name := range inputs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func inputUsage() string {
+	return strings.Join(inputNames(), ", ")
+}
+
+// remoteMessage is a MIDI message of a network client, such as a WebMIDI
+// page, mapped as received on port.
+type remoteMessage struct {
+	port int
+	msg  []byte
+}
+
+// remoteMidi queues the remote messages for the thread mapping the MIDI
+// input, which owns the mapping state. Waiting for cfgMu there would make
+// the JACK process callback miss its deadline.
+var remoteMidi = make(chan remoteMessage, 256)
+
+func queueRemote(port int, msg []byte) {
+	select {
+	case remoteMidi <- remoteMessage{port, msg}:
+	default:
+		slog.Warn("Remote MIDI queue full, dropping message", slog.String("msg", fmt.Sprintf("% X", msg)))
+	}
+}
+
+// handleRemote maps the queued remote messages, cfgMu held. It never
+// blocks.
+func handleRemote() {
+	defer func(port int) { inPort = port }(inPort)
+	for {
+		select {
+		case r := <-remoteMidi:
+			inPort = r.port
+			handleMessage(r.msg)
+		default:
+			return
+		}
+	}
+}
+
+// serveRemote maps the remote messages for the backends mapping from
+// goroutines, excluding them with the write lock of cfgMu.
+func serveRemote() {
+	for r := range remoteMidi {
+		cfgMu.Lock()
+		port := inPort
+		inPort = r.port
+		handleMessage(r.msg)
+		inPort = port
+		cfgMu.Unlock()
+	}
+}
+
+// discardMidiOut logs the MIDI output of the inputs without one.
+func discardMidiOut() {
+	for msg := range midiOut {
+		slog.Debug("MIDI output", slog.String("msg", fmt.Sprintf("% X", msg)))
+	}
+}
+
+// waitEvents waits until at most n events are queued for sending, so that
+// the inputs not bound to real time don't overflow the queue, dispatch
+// dropping events when it is full.
+func waitEvents(n int) {
+	for len(eventChan) > n {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// flushEvents waits until the events queued so far are sent, before
+// exiting at the end of the input.
+func flushEvents() {
+	done := make(chan struct{})
+	eventChan <- MidiEvent{flushed: done}
+	<-done
+}
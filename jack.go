@@ -0,0 +1,294 @@
+//go:build !nojack
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/xthexder/go-jack"
+)
+
+var (
+	portsIn     []*jack.Port
+	portOut     *jack.Port
+	jackParsers []midiParser
+	umpIns      []umpDecoder
+)
+
+func init() {
+	inputs["jack"] = func(name string) (midiInput, error) { return openJack(name) }
+}
+
+// jackReconnect is the delay between attempts to open the client again
+// after the JACK server stopped.
+const jackReconnect = 2 * time.Second
+
+// jackInput is a JACK client with a midi_in and a midi_out port. When the
+// server stops, the client is opened again once it is back and the ports
+// get their connections back.
+type jackInput struct {
+	name    string
+	changed chan struct{} // ports registered or connected
+
+	mu      sync.Mutex
+	client  *jack.Client
+	closed  bool
+	links   map[string][]string // peers of our ports by short name
+	pending map[string][]string // peers to connect again once they exist
+}
+
+func openJack(name string) (*jackInput, error) {
+	j := &jackInput{name: name, changed: make(chan struct{}, 1), links: map[string][]string{}, pending: map[string][]string{}}
+	if err := j.open(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// open opens the client and registers its ports.
+func (j *jackInput) open() error {
+	client, status := jack.ClientOpen(j.name, jack.NoStartServer)
+	if client == nil || status != 0 {
+		return fmt.Errorf("failed to open JACK client: status %d", status)
+	}
+
+	portFlags := uint64(jack.PortIsInput)
+	if useUMP {
+		portFlags |= jackPortIsMIDI2
+	}
+	devices := activeConfig.Load().MidiInputs
+	names := []string{"midi_in"}
+	if n := len(devices); n > 1 {
+		names = nil
+		for i := range n {
+			names = append(names, fmt.Sprintf("midi_in_%d", i+1))
+		}
+	}
+	var ports []*jack.Port
+	for i, name := range names {
+		port := client.PortRegister(name, jack.DEFAULT_MIDI_TYPE, portFlags, 0)
+		if port == nil {
+			client.Close()
+			return fmt.Errorf("failed to register MIDI input port %s", name)
+		}
+		attrs := []any{slog.String("name", port.GetName())}
+		if i < len(devices) {
+			attrs = append(attrs, slog.String("device", devices[i]))
+		}
+		slog.Info("Registered MIDI input port", attrs...)
+		ports = append(ports, port)
+	}
+	out := client.PortRegister("midi_out", jack.DEFAULT_MIDI_TYPE, jack.PortIsOutput, 0)
+	if out == nil {
+		client.Close()
+		return errors.New("failed to register MIDI output port")
+	}
+	slog.Info("Registered MIDI output port", slog.String("name", out.GetName()))
+
+	j.mu.Lock()
+	j.client = client
+	portsIn, portOut = ports, out
+	jackParsers = make([]midiParser, len(portsIn))
+	umpIns = make([]umpDecoder, len(portsIn))
+	j.mu.Unlock()
+	return nil
+}
+
+func (j *jackInput) start() error {
+	j.mu.Lock()
+	client := j.client
+	j.mu.Unlock()
+	if code := client.SetProcessCallback(process); code != 0 {
+		return fmt.Errorf("failed to set process callback: %s", jack.StrError(code))
+	}
+	// Closing the client or connecting from a JACK callback would deadlock
+	shutdown := make(chan struct{})
+	client.OnShutdown(func() {
+		close(shutdown)
+	})
+	notify := func() {
+		select {
+		case j.changed <- struct{}{}:
+		default:
+		}
+	}
+	client.SetPortRegistrationCallback(func(jack.PortId, bool) { notify() })
+	client.SetPortConnectCallback(func(jack.PortId, jack.PortId, bool) { notify() })
+
+	if code := client.Activate(); code != 0 {
+		return fmt.Errorf("failed to activate JACK client: %s", jack.StrError(code))
+	}
+	slog.Info("JACK client active", slog.String("name", client.GetName()))
+	notify()
+	go j.watchGraph(client, shutdown)
+	go j.supervise(shutdown)
+	return nil
+}
+
+// supervise opens the client again after the server stopped.
+func (j *jackInput) supervise(shutdown <-chan struct{}) {
+	<-shutdown
+	slog.Warn("JACK server stopped, reconnecting")
+	j.mu.Lock()
+	if j.closed {
+		j.mu.Unlock()
+		return
+	}
+	j.client.Close()
+	for name, peers := range j.links {
+		j.pending[name] = append(j.pending[name], peers...)
+	}
+	j.links = map[string][]string{}
+	j.mu.Unlock()
+	for {
+		time.Sleep(jackReconnect)
+		j.mu.Lock()
+		closed := j.closed
+		j.mu.Unlock()
+		if closed {
+			return
+		}
+		cfgMu.RLock()
+		err := j.open()
+		cfgMu.RUnlock()
+		if err != nil {
+			slog.Debug("JACK server not back", slog.Any("err", err))
+			continue
+		}
+		if err := j.start(); err != nil {
+			slog.Error("Failed to restart JACK client", slog.Any("err", err))
+			j.mu.Lock()
+			j.client.Close()
+			j.mu.Unlock()
+			continue
+		}
+		return
+	}
+}
+
+// watchGraph keeps the connections of the client while it runs: it
+// connects the MIDI outputs matching connect and restores the connections
+// lost when a port or the server went away, once they are back.
+func (j *jackInput) watchGraph(client *jack.Client, shutdown <-chan struct{}) {
+	for {
+		select {
+		case <-shutdown:
+			return
+		case <-j.changed:
+		}
+		cfgMu.RLock()
+		rules := activeConfig.Load().connect
+		cfgMu.RUnlock()
+		j.mu.Lock()
+		j.connectPorts(client, rules)
+		j.mu.Unlock()
+	}
+}
+
+func (j *jackInput) connectPorts(client *jack.Client, rules []connectRule) {
+	connected := false
+	connect := func(src, dst string) {
+		if code := client.Connect(src, dst); code != 0 {
+			slog.Warn("Failed to connect MIDI port", slog.String("src", src), slog.String("dst", dst), slog.Any("err", jack.StrError(code)))
+			return
+		}
+		slog.Info("Connected MIDI port", slog.String("src", src), slog.String("dst", dst))
+		connected = true
+	}
+	sources := client.GetPorts("", jack.DEFAULT_MIDI_TYPE, jack.PortIsOutput)
+	sinks := client.GetPorts("", jack.DEFAULT_MIDI_TYPE, jack.PortIsInput)
+	exists := func(port string) bool { return slices.Contains(sources, port) || slices.Contains(sinks, port) }
+
+	ports := append(slices.Clone(portsIn), portOut)
+	for i, port := range ports {
+		name, conns := port.GetShortName(), port.GetConnections()
+		// Peers gone since the last time, to connect again when back
+		for _, peer := range j.links[name] {
+			if !exists(peer) && !slices.Contains(j.pending[name], peer) {
+				j.pending[name] = append(j.pending[name], peer)
+			}
+		}
+		var pending []string
+		for _, peer := range j.pending[name] {
+			switch {
+			case slices.Contains(conns, peer):
+			case !exists(peer):
+				pending = append(pending, peer)
+			case port == portOut:
+				connect(port.GetName(), peer)
+			default:
+				connect(peer, port.GetName())
+			}
+		}
+		j.pending[name] = pending
+		if port != portOut {
+			for _, src := range sources {
+				if !slices.Contains(conns, src) && slices.ContainsFunc(rules, func(r connectRule) bool { return r.port == i+1 && r.re.MatchString(src) }) {
+					connect(src, port.GetName())
+				}
+			}
+		}
+		j.links[name] = port.GetConnections()
+	}
+	if connected {
+		requestIdentity()
+	}
+}
+
+func (j *jackInput) realtime() {}
+
+func (j *jackInput) close() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.closed = true
+	j.client.Close()
+}
+
+func process(nframes uint32) int {
+	writeMidiOut(nframes)
+
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+
+	if activeConfig.Load() == nil {
+		// Ne pas logger ici pour ne pas bloquer JACK
+		return 0
+	}
+
+	for i, port := range portsIn {
+		inPort = i + 1
+		for _, event := range port.GetMidiEvents(nframes) {
+			if useUMP {
+				umpIns[i].feed(event.Buffer, handleHiRes)
+			} else {
+				jackParsers[i].feed(event.Buffer, handleMessage)
+			}
+		}
+	}
+	handleRemote()
+	return 0
+}
+
+// writeMidiOut writes the queued messages at the start of the current
+// period. Called from the JACK process callback, so it never blocks.
+func writeMidiOut(nframes uint32) {
+	if portOut == nil {
+		return
+	}
+	buf := portOut.MidiClearBuffer(nframes)
+	for {
+		select {
+		case msg := <-midiOut:
+			if len(msg) > 0 {
+				portOut.MidiEventWrite(&jack.MidiData{Buffer: msg}, buf)
+			}
+		default:
+			return
+		}
+	}
+}
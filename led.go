@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// ledDevice describes how a grid controller lights its pads.
+type ledDevice struct {
+	note    func(x, y int) byte
+	status  byte                                 // Note On status carrying the color as velocity
+	palette map[string]byte                      // velocity by color name
+	rgb     func(note byte, r, g, b byte) []byte // SysEx for "#RRGGBB" colors, nil if unsupported
+}
+
+// Novation color palette, also used by the APC mini mk2.
+var novationPalette = map[string]byte{
+	"off": 0, "white": 3, "red": 5, "orange": 9, "yellow": 13, "lime": 17,
+	"green": 21, "cyan": 37, "blue": 45, "purple": 49, "magenta": 53, "pink": 57,
+}
+
+var ledDevices = map[string]ledDevice{
+	"launchpad_mk2":      {note: launchpadNote, status: 0x90, palette: novationPalette, rgb: launchpadRGB(0x18, 0x0B, 2)},
+	"launchpad_pro":      {note: launchpadNote, status: 0x90, palette: novationPalette, rgb: launchpadRGB(0x10, 0x0B, 2)},
+	"launchpad_x":        {note: launchpadNote, status: 0x90, palette: novationPalette, rgb: launchpadRGB(0x0C, 0x03, 1)},
+	"launchpad_mini_mk3": {note: launchpadNote, status: 0x90, palette: novationPalette, rgb: launchpadRGB(0x0D, 0x03, 1)},
+	"apc_mini": {note: apcNote, status: 0x90, palette: map[string]byte{
+		"off": 0, "green": 1, "green_blink": 2, "red": 3, "red_blink": 4, "yellow": 5, "yellow_blink": 6,
+	}},
+	"apc_mini_mk2": {note: apcNote, status: 0x96, palette: novationPalette}, // channel 7: full brightness
+}
+
+// launchpadNote returns the pad note in programmer mode: 11 bottom left to
+// 88 top right.
+func launchpadNote(x, y int) byte {
+	return byte((y+1)*10 + x + 1)
+}
+
+func apcNote(x, y int) byte {
+	return byte(y*8 + x)
+}
+
+// launchpadRGB returns the SysEx lighting a pad with an RGB color. Older
+// models take 6-bit components, newer ones 7-bit, hence shift.
+func launchpadRGB(model, cmd byte, shift uint) func(note, r, g, b byte) []byte {
+	return func(note, r, g, b byte) []byte {
+		msg := []byte{0xF0, 0x00, 0x20, 0x29, 0x02, model, cmd}
+		if cmd == 0x03 {
+			msg = append(msg, 0x03) // RGB lighting type
+		}
+		return append(msg, note, r>>shift, g>>shift, b>>shift, 0xF7)
+	}
+}
+
+// send queues the MIDI message lighting the pad for value.
+func (l *LedAction) send(value float64) {
+	msg, err := l.message(value)
+	if err != nil {
+		slog.Error("Failed to build LED message", slog.String("device", l.Device), slog.Any("err", err))
+		return
+	}
+	slog.Debug("LED", slog.String("device", l.Device), slog.Int("x", l.X), slog.Int("y", l.Y), slog.String("midi", fmt.Sprintf("% X", msg)))
+	sendMidi(msg)
+}
+
+func (l *LedAction) message(value float64) ([]byte, error) {
+	dev, ok := ledDevices[l.Device]
+	if !ok {
+		return nil, fmt.Errorf("unknown LED device %q", l.Device)
+	}
+	if l.X < 0 || l.X > 7 || l.Y < 0 || l.Y > 7 {
+		return nil, fmt.Errorf("pad %d,%d out of the 8x8 grid", l.X, l.Y)
+	}
+	color := l.Color
+	if c, ok := l.Colors[strconv.FormatFloat(value, 'f', -1, 64)]; ok {
+		color = c
+	}
+	note := dev.note(l.X, l.Y)
+
+	if rgb, ok := strings.CutPrefix(color, "#"); ok {
+		c, err := hex.DecodeString(rgb)
+		if err != nil || len(c) != 3 {
+			return nil, fmt.Errorf("invalid color %q", color)
+		}
+		if dev.rgb == nil {
+			return nil, fmt.Errorf("%s has no RGB colors", l.Device)
+		}
+		return dev.rgb(note, c[0], c[1], c[2]), nil
+	}
+	vel, ok := dev.palette[color]
+	if !ok {
+		return nil, fmt.Errorf("unknown color %q for %s", color, l.Device)
+	}
+	return []byte{dev.status, note, vel}, nil
+}
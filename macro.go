@@ -0,0 +1,67 @@
+package main
+
+import "fmt"
+
+// expandMacros replaces the macro actions of the mappings with the actions
+// of the macros they name. Macros may use other macros.
+func (c *Config) expandMacros() error {
+	for i := range c.Mappings {
+		m := &c.Mappings[i]
+		for _, actions := range []*[]OSCAction{&m.Actions, &m.OffActions, &m.OnTap, &m.OnDoubleTap, &m.OnHold} {
+			var err error
+			if *actions, err = c.expand(*actions, nil); err != nil {
+				return fmt.Errorf("mapping %d: %w", i+1, err)
+			}
+		}
+	}
+	var err error
+	if c.OnStart, err = c.expand(c.OnStart, nil); err != nil {
+		return fmt.Errorf("on_start: %w", err)
+	}
+	if c.OnExit, err = c.expand(c.OnExit, nil); err != nil {
+		return fmt.Errorf("on_exit: %w", err)
+	}
+	for i := range c.Timers {
+		if c.Timers[i].Actions, err = c.expand(c.Timers[i].Actions, nil); err != nil {
+			return fmt.Errorf("timer %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// expand returns actions with macros expanded, stack holding the macros
+// being expanded to detect cycles.
+func (c *Config) expand(actions []OSCAction, stack []string) ([]OSCAction, error) {
+	var out []OSCAction
+	for _, act := range actions {
+		if act.Macro == "" {
+			out = append(out, act)
+			continue
+		}
+		for _, name := range stack {
+			if name == act.Macro {
+				return nil, fmt.Errorf("macro %q uses itself", act.Macro)
+			}
+		}
+		macro, ok := c.Macros[act.Macro]
+		if !ok {
+			return nil, fmt.Errorf("unknown macro %q", act.Macro)
+		}
+		expanded, err := c.expand(macro, append(stack, act.Macro))
+		if err != nil {
+			return nil, err
+		}
+		// A delay on the macro action delays its first step, and its
+		// targets apply to the actions without their own
+		if len(expanded) > 0 {
+			expanded[0].DelayMs += act.DelayMs
+		}
+		for j := range expanded {
+			if len(expanded[j].OscTarget) == 0 {
+				expanded[j].OscTarget = act.OscTarget
+			}
+		}
+		out = append(out, expanded...)
+	}
+	return out, nil
+}
@@ -1,174 +1,1757 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"log/slog"
+	"math"
 	"os"
+	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/fjammes/midi2osc/resources"
-	"github.com/hypebeast/go-osc/osc"
-	"github.com/xthexder/go-jack"
 	"gopkg.in/yaml.v3"
 )
 
 type OSCAction struct {
-	Path  string      `yaml:"path"`
-	Type  string      `yaml:"type"`
-	Value interface{} `yaml:"value"`
+	Path      string      `yaml:"path"`
+	Type      string      `yaml:"type"`
+	Value     interface{} `yaml:"value"`
+	OscTarget TargetList  `yaml:"osc_target"` // overrides the mapping targets
+	Args      []OSCArg    `yaml:"args"`       // several arguments, replaces type and value
+	Transform *Transform  `yaml:"transform"`
+
+	DelayMs int `yaml:"delay_ms"` // wait before this step, the next actions waiting too
+
+	// Scheduled execution by the receiver, sent as a bundle with a future timetag
+	TimetagDelayMs int    `yaml:"timetag_delay_ms"`
+	At             string `yaml:"at"` // RFC 3339 time, or "15:04:05" for the next occurrence of that time of day
+
+	Macro string `yaml:"macro"` // replaced by the actions of this macro
+
+	Midi   *MidiAction   `yaml:"midi"`   // sends a MIDI message on midi_out instead of OSC
+	Led    *LedAction    `yaml:"led"`    // lights a pad of a grid controller on midi_out instead of OSC
+	MQTT   *MQTTAction   `yaml:"mqtt"`   // publishes to the mqtt broker of the config instead of OSC
+	HTTP   *HTTPAction   `yaml:"http"`   // calls a URL instead of sending OSC
+	Exec   *ExecAction   `yaml:"exec"`   // runs a local command instead of sending OSC
+	ArtNet *ArtNetAction `yaml:"artnet"` // sets a DMX channel over Art-Net instead of sending OSC
+
+	Set map[string]interface{} `yaml:"set"` // sets variables instead of sending OSC, e.g. {selected: $note}
+
+	Profile string `yaml:"profile"` // switches to this profile instead of sending OSC
+}
+
+// MidiAction describes a MIDI message sent by an action: a CC, a note or a
+// raw SysEx message.
+type MidiAction struct {
+	Channel *MidiNumber `yaml:"channel"` // 1-16, the channel of the event when unset
+	CC      *MidiNumber `yaml:"cc"`
+	Note    *MidiNumber `yaml:"note"`
+	Value   interface{} `yaml:"value"` // CC value or note velocity, the MIDI value of the event when unset
+	SysEx   string      `yaml:"sysex"` // hex bytes, e.g. "F0 7E 7F 06 01 F7"
+}
+
+// LedAction lights the pad at column X, row Y (0-7 from the bottom left) of
+// a grid controller. The color is a name, or "#RRGGBB" on Launchpads.
+type LedAction struct {
+	Device string            `yaml:"device"` // see ledDevices
+	X      int               `yaml:"x"`
+	Y      int               `yaml:"y"`
+	Color  string            `yaml:"color"`
+	Colors map[string]string `yaml:"colors"` // color by value, e.g. {"0": off, "1": red}, overrides color
+}
+
+// MQTTAction publishes a message, the MIDI value of the event when payload
+// is unset. The topic may hold templates such as {{note}}.
+type MQTTAction struct {
+	Topic    string      `yaml:"topic"`
+	Payload  interface{} `yaml:"payload"` // a value, $ placeholders and templates allowed
+	QoS      byte        `yaml:"qos"`     // 0, 1 or 2
+	Retained bool        `yaml:"retained"`
+}
+
+// HTTPAction calls a web hook or a REST API. The URL, header values and
+// body may hold templates such as {{value}}.
+type HTTPAction struct {
+	Method  string            `yaml:"method"` // GET, or POST with a body, when unset
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Body    string            `yaml:"body"`
+}
+
+// ExecAction runs a command, e.g. [notify-send, "Volume {{value}}"], or
+// [sh, -c, "..."] reading $MIDI2OSC_VALUE. It is killed after its timeout.
+type ExecAction struct {
+	Command   []string `yaml:"command"`
+	TimeoutMs int      `yaml:"timeout_ms"` // 10s when unset
+}
+
+// ArtNetAction sets a DMX channel of an Art-Net node, e.g. a dimmer
+// following a fader.
+type ArtNetAction struct {
+	Target   string      `yaml:"target"`   // host or host:port of the node, broadcast when unset
+	Universe uint16      `yaml:"universe"` // 15-bit port address: net, sub-net and universe
+	Channel  int         `yaml:"channel"`  // 1-512
+	Value    interface{} `yaml:"value"`    // 0-255, the value of the event scaled when unset
+}
+
+type OSCArg struct {
+	Type      string      `yaml:"type"`
+	Value     interface{} `yaml:"value"`
+	Transform *Transform  `yaml:"transform"`
+}
+
+// TargetList is one or more OSC target URLs, written in YAML as a single
+// string or as a list.
+type TargetList []string
+
+func (l *TargetList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = TargetList{node.Value}
+		return nil
+	}
+	var targets []string
+	if err := node.Decode(&targets); err != nil {
+		return err
+	}
+	*l = targets
+	return nil
+}
+
+func (l TargetList) MarshalYAML() (interface{}, error) {
+	if len(l) == 1 {
+		return l[0], nil
+	}
+	return []string(l), nil
 }
 
 type Mapping struct {
-	CC      uint8       `yaml:"cc"`
-	Value   uint8       `yaml:"value"`
+	CC      *MidiNumber `yaml:"cc"`
+	Note    *MidiNumber `yaml:"note"`
+	Notes   *ValueRange `yaml:"notes"` // keyboard zone, e.g. "36-59"
+	Value   *ValueRange `yaml:"value"` // CC value or note velocity, 0 matches Note Off
 	Actions []OSCAction `yaml:"actions"`
+
+	OscTarget TargetList `yaml:"osc_target"` // overrides the global targets
+
+	Bundle        bool  `yaml:"bundle"`          // send all actions to a target as one OSC bundle
+	BundleDelayMs int   `yaml:"bundle_delay_ms"` // bundle timetag in the future, immediate when unset
+	MinIntervalMs int   `yaml:"min_interval_ms"` // coalesce events closer than this, sending the latest
+	Ramp          *Ramp `yaml:"ramp"`            // smooth large value jumps
+	Deadzone      int   `yaml:"deadzone"`        // ignore changes of at most this many steps from the last value
+
+	// Sends when any of these CCs changes, their last values being $cc[0], $cc[1]...
+	Combine []MidiNumber `yaml:"combine"`
+	Pickup  bool         `yaml:"pickup"` // soft takeover: ignore the control until it reaches the value set by feedback
+
+	Name        string `yaml:"name"`        // shown in logs, e.g. "Master mute"
+	Description string `yaml:"description"` // published with OSCQuery
+
+	Stop    *bool    `yaml:"stop"`    // don't try the next mappings once this one fired, see Config.Match
+	Enabled *bool    `yaml:"enabled"` // false disables the mapping
+	Tags    []string `yaml:"tags"`    // groups of mappings that can be disabled at runtime
+
+	// Edge trigger: fire only when the CC value crosses the threshold
+	Threshold *MidiNumber `yaml:"threshold"`
+	Edge      string      `yaml:"edge"` // "rising" (default), "falling" or "both"
+
+	Bank int        `yaml:"bank"` // only active in this bank, all banks when unset
+	When *Condition `yaml:"when"` // only active while another control is held, e.g. a shift button
+
+	Script string `yaml:"script"` // Lua file whose on_midi(event) returns more messages to send
+
+	// Gestures of a button, replacing actions: tap, double tap and hold
+	OnTap       []OSCAction `yaml:"on_tap"`
+	OnDoubleTap []OSCAction `yaml:"on_double_tap"`
+	OnHold      []OSCAction `yaml:"on_hold"`
+	HoldMs      int         `yaml:"hold_ms"`       // press duration of a hold, 500 when unset
+	DoubleTapMs int         `yaml:"double_tap_ms"` // max delay between the taps of a double tap, 300 when unset
+
+	Mode       string      `yaml:"mode"`        // "toggle": presses alternate between actions and off_actions
+	OffActions []OSCAction `yaml:"off_actions"` // toggle mode: actions when switched off
+
+	SkipDuplicates *bool `yaml:"skip_duplicates"` // overrides the global setting
+
+	ValueMin *MidiNumber `yaml:"value_min"`
+	ValueMax *MidiNumber `yaml:"value_max"`
+
+	Channel        *MidiNumber   `yaml:"channel"` // 1-16, any channel when unset
+	PitchBend      bool          `yaml:"pitchbend"`
+	Aftertouch     bool          `yaml:"aftertouch"`      // channel pressure
+	PolyAftertouch bool          `yaml:"poly_aftertouch"` // poly key pressure, optionally for a single note
+	Range          [2]float64    `yaml:"range"`           // scaled output range for $midi and $bend
+	CC14           bool          `yaml:"cc14"`            // combine cc (0-31) and cc+32 into a 14-bit value
+	NRPN           *ParamNumber  `yaml:"nrpn"`            // parameter number, 0-16383
+	RPN            *ParamNumber  `yaml:"rpn"`
+	SysEx          *SysExPattern `yaml:"sysex"`        // hex bytes, "??" matches any byte and a trailing "*" any tail
+	EncoderMode    string        `yaml:"encoder_mode"` // twos_complement, binary_offset or sign_magnitude
+	MMC            string        `yaml:"mmc"`          // MMC command: play, stop, record, locate...
+	Control        string        `yaml:"control"`      // named control of the controller, replaces cc or note
+	Port           int           `yaml:"port"`         // input port midi_in_N, any port when unset
+	Device         string        `yaml:"device"`       // input port by its name in midi_inputs, or webmidi
+
+	profile string // name of the profile defining the mapping, empty for shared mappings
+	source  string // file:line of the mapping, for messages
+}
+
+// crossed reports whether a CC going from prev to val crosses the
+// threshold of an edge trigger mapping in its direction. Mappings without
+// threshold always fire.
+func (m *Mapping) crossed(prev uint8, hasPrev bool, val uint8) bool {
+	if m.Threshold == nil {
+		return true
+	}
+	t := uint8(*m.Threshold)
+	rising := (!hasPrev || prev < t) && val >= t
+	falling := hasPrev && prev >= t && val < t
+	switch m.Edge {
+	case "falling":
+		return falling
+	case "both":
+		return rising || falling
+	}
+	return rising
+}
+
+// Ramp replaces value jumps larger than Threshold (on the 0-127 scale) by
+// intermediate values, Threshold apart.
+type Ramp struct {
+	Threshold  int `yaml:"threshold"`
+	IntervalMs int `yaml:"interval_ms"` // delay between intermediate values, 10 when unset
+}
+
+// Condition tests the live state of a CC or note: its last value, or the
+// velocity of a held note (0 once released).
+type Condition struct {
+	Channel *MidiNumber `yaml:"channel"` // 1-16, any channel when unset
+	CC      *MidiNumber `yaml:"cc"`
+	Note    *MidiNumber `yaml:"note"`
+	Value   *ValueRange `yaml:"value"` // ">0" when unset: pressed or held
+}
+
+// ValueRange is an inclusive range of MIDI data values, written in YAML
+// either as a single number, as "min-max" (e.g. "64-127") or as a
+// comparison (e.g. ">0", "<=63"). Numbers may be hexadecimal, e.g. "0x40-0x7F".
+type ValueRange struct {
+	Min, Max uint8
+}
+
+func (r *ValueRange) UnmarshalYAML(node *yaml.Node) error {
+	for _, op := range []string{">=", "<=", "==", ">", "<"} {
+		v, ok := strings.CutPrefix(node.Value, op)
+		if !ok {
+			continue
+		}
+		n, err := parseMidiNumber(v)
+		if err != nil || (op == ">" && n == 127) || (op == "<" && n == 0) {
+			return fmt.Errorf("line %d: invalid value %q", node.Line, node.Value)
+		}
+		switch op {
+		case ">=":
+			r.Min, r.Max = uint8(n), 127
+		case "<=":
+			r.Min, r.Max = 0, uint8(n)
+		case "==":
+			r.Min, r.Max = uint8(n), uint8(n)
+		case ">":
+			r.Min, r.Max = uint8(n)+1, 127
+		case "<":
+			r.Min, r.Max = 0, uint8(n)-1
+		}
+		return nil
+	}
+
+	lo, hi, found := strings.Cut(node.Value, "-")
+	if !found {
+		hi = lo
+	}
+	min, err := parseMidiNumber(lo)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid value %q", node.Line, node.Value)
+	}
+	max, err := parseMidiNumber(hi)
+	if err != nil || max < min {
+		return fmt.Errorf("line %d: invalid value range %q", node.Line, node.Value)
+	}
+	r.Min, r.Max = uint8(min), uint8(max)
+	return nil
+}
+
+// parseMidiNumber parses a 7-bit number, decimal or hexadecimal with a 0x
+// prefix as in MIDI implementation charts. A leading 0 is not octal.
+func parseMidiNumber(s string) (uint64, error) {
+	return parseMidiBits(s, 7)
+}
+
+func parseMidiBits(s string, bits int) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if h, ok := strings.CutPrefix(strings.ToLower(s), "0x"); ok {
+		return strconv.ParseUint(h, 16, bits)
+	}
+	return strconv.ParseUint(s, 10, bits)
+}
+
+// MidiNumber is a 7-bit number of the config, a CC, a note or a channel,
+// written in decimal or in hexadecimal as in MIDI implementation charts,
+// e.g. 0x0B, or "0x0B" in JSON configs which are read as YAML.
+type MidiNumber uint8
+
+func (n *MidiNumber) UnmarshalYAML(node *yaml.Node) error {
+	v, err := parseMidiNumber(node.Value)
+	if err != nil || node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: invalid MIDI number %q", node.Line, node.Value)
+	}
+	*n = MidiNumber(v)
+	return nil
+}
+
+// ParamNumber is a 14-bit NRPN or RPN parameter number, decimal or
+// hexadecimal like MidiNumber.
+type ParamNumber uint16
+
+func (n *ParamNumber) UnmarshalYAML(node *yaml.Node) error {
+	v, err := parseMidiBits(node.Value, 14)
+	if err != nil || node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: invalid parameter number %q", node.Line, node.Value)
+	}
+	*n = ParamNumber(v)
+	return nil
+}
+
+func (r ValueRange) MarshalYAML() (interface{}, error) {
+	if r.Min == r.Max {
+		return int(r.Min), nil
+	}
+	return fmt.Sprintf("%d-%d", r.Min, r.Max), nil
+}
+
+type SysExPattern struct {
+	bytes []int // -1 is a wildcard
+	tail  bool
+}
+
+func (p *SysExPattern) UnmarshalYAML(node *yaml.Node) error {
+	fields := strings.Fields(node.Value)
+	for i, f := range fields {
+		switch {
+		case f == "*" && i == len(fields)-1:
+			p.tail = true
+		case f == "??" || strings.EqualFold(f, "xx"):
+			p.bytes = append(p.bytes, -1)
+		default:
+			b, err := strconv.ParseUint(f, 16, 8)
+			if err != nil {
+				return fmt.Errorf("line %d: invalid sysex byte %q", node.Line, f)
+			}
+			p.bytes = append(p.bytes, int(b))
+		}
+	}
+	return nil
+}
+
+func (p SysExPattern) MarshalYAML() (interface{}, error) {
+	var fields []string
+	for _, b := range p.bytes {
+		if b < 0 {
+			fields = append(fields, "??")
+		} else {
+			fields = append(fields, fmt.Sprintf("%02X", b))
+		}
+	}
+	if p.tail {
+		fields = append(fields, "*")
+	}
+	return strings.Join(fields, " "), nil
+}
+
+func (p *SysExPattern) match(msg []byte) bool {
+	if len(msg) < len(p.bytes) || (!p.tail && len(msg) != len(p.bytes)) {
+		return false
+	}
+	for i, b := range p.bytes {
+		if b >= 0 && msg[i] != byte(b) {
+			return false
+		}
+	}
+	return true
 }
 
 type Config struct {
-	OscTarget string    `yaml:"osc_target"`
-	Mappings  []Mapping `yaml:"mappings"`
+	OscTarget     TargetList      `yaml:"osc_target"`
+	Mappings      []Mapping       `yaml:"mappings"`
+	CC14TimeoutMs int             `yaml:"cc14_timeout_ms"` // max delay between MSB and LSB, 50ms when unset
+	MPE           *MPEConfig      `yaml:"mpe"`
+	Clock         *ClockConfig    `yaml:"clock"`
+	MTC           *MTCConfig      `yaml:"mtc"`
+	Filters       *Filters        `yaml:"filters"`
+	RawMidi       *RawMidi        `yaml:"raw_midi"`
+	Feedback      *Feedback       `yaml:"feedback"`
+	OSCQuery      *OSCQueryConfig `yaml:"oscquery"`
+	WebMIDI       *WebMIDIConfig  `yaml:"webmidi"`
+	MQTT          *MQTTConfig     `yaml:"mqtt"`
+	Metrics       *MetricsConfig  `yaml:"metrics"`
+	Banks         *Banks          `yaml:"banks"`
+
+	SkipDuplicates bool `yaml:"skip_duplicates"` // don't resend the last value sent to a path
+
+	Match string `yaml:"match"` // "all" (default) mappings matching a message fire, or only the "first"
+
+	Targets map[string]TargetOptions `yaml:"targets"` // keyed by target URL
+
+	Macros map[string][]OSCAction `yaml:"macros"` // named action lists, used with {macro: name}
+
+	OnStart []OSCAction `yaml:"on_start"` // sent once the JACK client is active
+	OnExit  []OSCAction `yaml:"on_exit"`  // sent on Ctrl+C or SIGTERM
+
+	Timers []Timer `yaml:"timers"`
+
+	Variables map[string]interface{} `yaml:"variables"` // initial values of the shared variables
+
+	Include []string `yaml:"include"` // files merged into this one, relative to it, globs allowed
+
+	Generate []Generator `yaml:"generate"` // mapping templates expanded over a range
+
+	Profiles map[string][]Mapping `yaml:"profiles"` // mapping sets added to the shared mappings, one active at a time
+	Profile  string               `yaml:"profile"`  // profile active at startup
+
+	Controller string `yaml:"controller"` // built-in controller or definition file naming the controls, auto to identify it
+
+	ClientName string   `yaml:"client_name"` // JACK client and announced name, "midi2osc" when unset
+	MidiInputs []string `yaml:"midi_inputs"` // names of the JACK input ports midi_in_1, midi_in_2... one midi_in when unset
+	Connect    []string `yaml:"connect"`     // MIDI outputs to connect when they appear, regexps such as "a2j:.*nanoKONTROL.*", "client:port" with ALSA
+
+	files   []string      // loaded files and directories, watched for changes
+	connect []connectRule // compiled Connect and -connect
+}
+
+// TargetOptions tunes the transport to one OSC target.
+type TargetOptions struct {
+	Policy     string `yaml:"policy"`      // TCP: "queue" (default) or "drop" messages while disconnected
+	QueueSize  int    `yaml:"queue_size"`  // TCP: 256 when unset
+	Framing    string `yaml:"framing"`     // TCP: "length" prefix (OSC 1.0, default) or "slip" (OSC 1.1)
+	Retries    int    `yaml:"retries"`     // resend failed packets up to this many times, 0 disables
+	RetryQueue int    `yaml:"retry_queue"` // max packets waiting for a retry, 64 when unset
+
+	CA         string `yaml:"ca"`          // TLS: CA bundle verifying the server, system roots when unset
+	Cert       string `yaml:"cert"`        // TLS: client certificate
+	Key        string `yaml:"key"`         // TLS: client private key
+	ServerName string `yaml:"server_name"` // TLS: name to verify, the target host when unset
+
+	TTL       int    `yaml:"ttl"`       // UDP multicast: hop limit, 1 when unset
+	Interface string `yaml:"interface"` // UDP multicast: outgoing interface name
+
+	DialTimeoutMs  int `yaml:"dial_timeout_ms"`  // 2000 when unset
+	WriteTimeoutMs int `yaml:"write_timeout_ms"` // 1000 when unset
+}
+
+// RawMidi mirrors every incoming MIDI message as an OSC blob, in addition
+// to the mappings.
+type RawMidi struct {
+	Path string `yaml:"path"` // "/midi/raw" when unset
+}
+
+// Filters drops MIDI events before they are logged or mapped.
+type Filters struct {
+	CCs      []MidiNumber `yaml:"cc"`
+	Channels []MidiNumber `yaml:"channels"` // 1-16
+	Types    []string     `yaml:"types"`    // see midiType
+}
+
+func (f *Filters) drop(buf []byte) bool {
+	if len(buf) == 0 {
+		return true
+	}
+	typ := midiType(buf[0])
+	if slices.Contains(f.Types, typ) {
+		return true
+	}
+	if buf[0] < 0xF0 && slices.Contains(f.Channels, MidiNumber(buf[0]&0x0F+1)) {
+		return true
+	}
+	return typ == "cc" && len(buf) >= 2 && slices.Contains(f.CCs, MidiNumber(buf[1]))
+}
+
+// MTCConfig forwards MIDI Time Code as an "HH:MM:SS:FF" string.
+type MTCConfig struct {
+	Path string `yaml:"path"` // "/timecode" when unset
+}
+
+// ClockConfig bridges MIDI clock and transport messages to OSC.
+type ClockConfig struct {
+	Path         string `yaml:"path"` // receives the tempo as a float, "/tempo" when unset
+	StartPath    string `yaml:"start_path"`
+	StopPath     string `yaml:"stop_path"`
+	ContinuePath string `yaml:"continue_path"`
+}
+
+// MPEConfig enables per-note OSC messages for MPE controllers. Every
+// channel except the master channel is a member channel carrying one note.
+type MPEConfig struct {
+	Path      string     `yaml:"path"`           // address prefix, "/note" when unset
+	Master    MidiNumber `yaml:"master_channel"` // 1 (lower zone) or 16 (upper zone), 1 when unset
+	BendRange float64    `yaml:"bend_range"`     // semitones, 48 when unset
+}
+
+// Timer sends actions periodically, e.g. a heartbeat or the keep-alive
+// some consoles need to maintain subscriptions.
+type Timer struct {
+	IntervalMs int         `yaml:"interval_ms"`
+	Actions    []OSCAction `yaml:"actions"`
+	OscTarget  TargetList  `yaml:"osc_target"` // overrides the global targets
+}
+
+// Banks lets a few controls address several pages of mappings: the bank
+// controls select the active bank, and mappings with a bank only fire
+// while it is selected.
+type Banks struct {
+	Count   int           `yaml:"count"`
+	Channel *MidiNumber   `yaml:"channel"` // 1-16, any channel when unset
+	Next    *BankControl  `yaml:"next"`
+	Prev    *BankControl  `yaml:"prev"`
+	Select  []BankControl `yaml:"select"` // one control per bank, in order
+	Path    string        `yaml:"path"`   // notification of bank changes, "/bank" when unset
+}
+
+type BankControl struct {
+	CC   *MidiNumber `yaml:"cc"`
+	Note *MidiNumber `yaml:"note"`
+}
+
+// OSCQueryConfig serves the OSC namespace to OSCQuery clients.
+type OSCQueryConfig struct {
+	Listen string `yaml:"listen"` // HTTP address, e.g. ":5678"
+	Name   string `yaml:"name"`   // announced name, the client name when unset
+}
+
+// WebMIDIConfig serves a page forwarding the MIDI inputs of a browser,
+// with the Web MIDI API, over a WebSocket. They are mapped as if received
+// on the webmidi device, so a tablet can be a remote controller.
+type WebMIDIConfig struct {
+	Listen  string   `yaml:"listen"`  // HTTP address, localhost:8090 when unset, e.g. ":8090" for the LAN
+	Token   string   `yaml:"token"`   // required in the URL, ?token=..., when set
+	Origins []string `yaml:"origins"` // other pages allowed to connect, e.g. "https://example.org"
+}
+
+// MetricsConfig serves the counters of midi2osc, see startMetrics.
+type MetricsConfig struct {
+	Listen string `yaml:"listen"` // HTTP address, localhost:6060 when unset
+}
+
+// MQTTConfig is the broker of the mqtt actions.
+type MQTTConfig struct {
+	Broker   string `yaml:"broker"`    // e.g. "tcp://localhost:1883", ssl:// or ws:// too
+	ClientID string `yaml:"client_id"` // the client name when unset
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// Feedback maps OSC messages received from a DAW or mixer back to MIDI on
+// the midi_out port, so motorized faders and LED rings follow its state.
+type Feedback struct {
+	Listen   string            `yaml:"listen"` // UDP address, e.g. ":9001"
+	Mappings []FeedbackMapping `yaml:"mappings"`
+
+	// Values coming back within this window after we sent them are ignored
+	// to break feedback loops. 200ms when unset, negative disables.
+	EchoWindowMs int `yaml:"echo_window_ms"`
+}
+
+type FeedbackMapping struct {
+	Path    string      `yaml:"path"`    // OSC address, may contain * ? [] wildcards
+	Channel *MidiNumber `yaml:"channel"` // 1-16, 1 when unset
+	CC      *MidiNumber `yaml:"cc"`
+	Note    *MidiNumber `yaml:"note"`  // the value is sent as velocity
+	Arg     int         `yaml:"arg"`   // index of the argument carrying the value
+	Range   [2]float64  `yaml:"range"` // OSC values scaled to 0-127, used as is when unset
+	Led     *LedAction  `yaml:"led"`   // lights a pad instead of sending a CC or note
 }
 
 type MidiEvent struct {
-	CC      uint8
-	Value   uint8
-	Target  string
-	Actions []OSCAction
+	Channel  uint8 // 1-16
+	CC       uint8
+	Note     uint8
+	Value    int64
+	Max      int64 // full scale of Value, 127 when unset, 0xFFFFFFFF for MIDI 2.0 32-bit values
+	Values   []int // combine mappings: values of the combined CCs, as $cc[0], $cc[1]...
+	Velocity uint8
+	Bend     float64 // pitch bend scaled to the mapping range
+	Pressure uint8
+	Range    [2]float64 // scaling applied to $midi, raw value when unset
+	Data     []byte     // complete SysEx message for $sysex[...]
+	Locate   string     // MMC locate target as HH:MM:SS:FF
+	Mapping  *Mapping   // nil for built-in messages (clock, MTC, raw mirror...)
+	Target   TargetList
+	Actions  []OSCAction
+
+	coalesced bool // already delayed by throttle
+	ramped    bool // intermediate value of a ramp
+	step      bool // remaining actions of a sequence, after a delay
+	bundled   bool // sent as one bundle per target, as with Mapping.Bundle
+
+	flushed chan struct{} // marker closed by the sender when reached, see flushEvents
 }
 
+// activeConfig is the running config, swapped on reload. handleHiRes loads
+// it once per MIDI message and passes it down to the handlers, so a message
+// is handled with a single config.
+var activeConfig atomic.Pointer[Config]
+
+// cfgMu is held by the JACK process callback while it handles a cycle, so
+// the config is not swapped in the middle of it.
+var cfgMu sync.RWMutex
+
+// stopMatching is set once a mapping that stops matching has fired for the
+// current MIDI message. Only accessed from the JACK thread.
+var stopMatching bool
+
 var (
-	portIn    *jack.Port
-	ch        chan string // for printing midi events
-	cfg       *Config
-	eventChan chan MidiEvent // global channel for OSC events
+	useUMP     bool
+	ch         chan string           // for printing midi events
+	inputEnded = make(chan struct{}) // closed by the inputs ending the program, a MIDI file or stdin
+	configPath string
+	eventChan  chan MidiEvent // global channel for OSC events
 )
 
-func loadConfig(path string) (*Config, error) {
-	b, err := os.ReadFile(path)
+// parseConfig decodes and resolves a YAML config.
+func parseConfig(b []byte) (*Config, error) {
+	c, err := decodeConfig(b)
 	if err != nil {
 		return nil, err
 	}
-	var cfg Config
-	if err := yaml.Unmarshal(b, &cfg); err != nil {
+	if err := c.resolve(); err != nil {
 		return nil, err
 	}
+	return c, nil
+}
+
+// resolve turns a decoded config into the config that runs.
+func (c *Config) resolve() error {
+	if err := c.applyOverrides(); err != nil {
+		return err
+	}
+	if err := c.generateMappings(); err != nil {
+		return err
+	}
+	if err := c.addProfiles(); err != nil {
+		return err
+	}
+	if err := c.resolveControls(); err != nil {
+		return err
+	}
+	if err := c.resolveDevices(); err != nil {
+		return err
+	}
+	if err := c.resolveConnect(); err != nil {
+		return err
+	}
+	if err := c.expandMacros(); err != nil {
+		return err
+	}
+	return c.expandEnv()
+}
+
+// clientNameFlag is the -client-name flag.
+var clientNameFlag string
+
+// clientName names the MIDI client and the announced services, so that
+// several instances can run side by side.
+func (c *Config) clientName() string {
+	switch {
+	case clientNameFlag != "":
+		return clientNameFlag
+	case c.ClientName != "":
+		return c.ClientName
+	}
+	return "midi2osc"
+}
+
+// decodeConfig decodes a YAML config. Unknown fields are errors, so typos
+// are not silently ignored.
+func decodeConfig(b []byte) (*Config, error) {
+	var cfg Config
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&cfg); err != nil && err != io.EOF {
+		return nil, suggestFields(err)
+	}
 	return &cfg, nil
 }
 
-func sendOSC(target, path, t string, val interface{}) error {
-	if !strings.HasPrefix(target, "osc.tcp://") {
-		return fmt.Errorf("only osc.tcp:// supported")
-	}
-	addr := strings.TrimPrefix(target, "osc.tcp://")
-	parts := strings.Split(addr, ":")
-	if len(parts) != 2 {
-		return fmt.Errorf("invalid OSC address format")
-	}
-	client := osc.NewClient(parts[0], atoi(parts[1]))
-	msg := osc.NewMessage(path)
-	switch t {
-	case "i":
-		msg.Append(int32(val.(int)))
-	case "f":
-		msg.Append(float32(val.(float64)))
-	case "s":
-		msg.Append(val.(string))
-	case "T":
-		msg.Append(true)
-	case "F":
-		msg.Append(false)
-	default:
-		return fmt.Errorf("unsupported OSC type: %s", t)
+// resolveValue replaces MIDI placeholders in an action value with data
+// from the event that triggered it.
+func resolveValue(val interface{}, ev MidiEvent) interface{} {
+	switch val {
+	case "$midi":
+		return scaleValue(ev.Value, ev.Max, ev.Range)
+	case "$velocity":
+		return int(ev.Velocity)
+	case "$bend":
+		return ev.Bend
+	case "$pressure":
+		return int(ev.Pressure)
+	case "$note":
+		return int(ev.Note)
+	case "$channel":
+		return int(ev.Channel)
+	case "$locate":
+		return ev.Locate
+	}
+	if s, ok := val.(string); ok {
+		if strings.HasPrefix(s, "$sysex[") && strings.HasSuffix(s, "]") {
+			return sysexValue(s[len("$sysex["):len(s)-1], ev.Data, val)
+		}
+		if strings.HasPrefix(s, "$cc[") && strings.HasSuffix(s, "]") {
+			i, err := strconv.Atoi(s[len("$cc[") : len(s)-1])
+			if err != nil || i < 0 || i >= len(ev.Values) {
+				return val
+			}
+			return scaleValue(int64(ev.Values[i]), 127, ev.Range)
+		}
+		return expandTemplate(s, ev)
 	}
-	return client.Send(msg)
+	return val
 }
 
-func atoi(s string) int {
-	var i int
-	fmt.Sscanf(s, "%d", &i)
-	return i
+// scaleValue maps a value of full scale max (127 when 0) to rng, or returns
+// it unchanged when rng is unset.
+func scaleValue(value, max int64, rng [2]float64) interface{} {
+	if rng == [2]float64{} {
+		return value
+	}
+	if max == 0 {
+		max = 127
+	}
+	return rng[0] + float64(value)/float64(max)*(rng[1]-rng[0])
+}
+
+// expandTemplate replaces {{name}} placeholders with event data. Unknown
+// names are left untouched.
+func expandTemplate(s string, ev MidiEvent) string {
+	if !strings.Contains(s, "{{") {
+		return s
+	}
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "{{")
+		if start < 0 {
+			break
+		}
+		end := strings.Index(s[start:], "}}")
+		if end < 0 {
+			break
+		}
+		end += start
+		b.WriteString(s[:start])
+		if v, ok := templateVar(strings.TrimSpace(s[start+2:end]), ev); ok {
+			b.WriteString(v)
+		} else {
+			b.WriteString(s[start : end+2])
+		}
+		s = s[end+2:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+func templateVar(name string, ev MidiEvent) (string, bool) {
+	switch name {
+	case "cc":
+		return strconv.Itoa(int(ev.CC)), true
+	case "value":
+		return strconv.FormatInt(ev.Value, 10), true
+	case "channel":
+		return strconv.Itoa(int(ev.Channel)), true
+	case "note":
+		return strconv.Itoa(int(ev.Note)), true
+	case "velocity":
+		return strconv.Itoa(int(ev.Velocity)), true
+	case "pressure":
+		return strconv.Itoa(int(ev.Pressure)), true
+	case "bend":
+		return strconv.FormatFloat(ev.Bend, 'f', -1, 64), true
+	}
+	if v, ok := strings.CutPrefix(name, "var."); ok {
+		return variable(v)
+	}
+	return "", false
+}
+
+// sysexValue extracts a byte ("5") as an int or a byte range ("5:12") as
+// a string from a SysEx message.
+func sysexValue(idx string, data []byte, dflt interface{}) interface{} {
+	lo, hi, isRange := strings.Cut(idx, ":")
+	start, err := strconv.Atoi(lo)
+	if err != nil || start < 0 || start >= len(data) {
+		return dflt
+	}
+	if !isRange {
+		return int(data[start])
+	}
+	end := len(data)
+	if hi != "" {
+		if end, err = strconv.Atoi(hi); err != nil || end < start || end > len(data) {
+			return dflt
+		}
+	}
+	return string(data[start:end])
+}
+
+// handleMessage filters, logs and maps one complete MIDI message.
+func handleMessage(msg []byte) {
+	handleHiRes(msg, hiRes{})
+}
+
+// hiRes carries the full resolution value of a MIDI 2.0 message that was
+// converted to MIDI 1.0 for matching. A zero max means there is none.
+type hiRes struct {
+	value, max int64
+}
+
+func (hr hiRes) or(value, max int64) (int64, int64) {
+	if hr.max == 0 {
+		return value, max
+	}
+	return hr.value, hr.max
+}
+
+func handleHiRes(msg []byte, hr hiRes) {
+	cfg := activeConfig.Load()
+	stopMatching = false
+	if cfg.Filters != nil && cfg.Filters.drop(msg) {
+		return
+	}
+	if isMidiEcho(msg) {
+		return
+	}
+
+	// Ne jamais bloquer dans le thread JACK :
+	select {
+	case ch <- fmt.Sprintf("% X", msg):
+	default:
+		// Si le chan est plein, on saute sans bloquer
+	}
+
+	if cfg.RawMidi != nil {
+		path := cfg.RawMidi.Path
+		if path == "" {
+			path = "/midi/raw"
+		}
+		dispatch(MidiEvent{
+			Target:  cfg.OscTarget,
+			Actions: []OSCAction{{Path: path, Type: "b", Value: msg}},
+		})
+	}
+
+	handleMidi(cfg, msg, hr)
 }
 
-func process(nframes uint32) int {
-	events := portIn.GetMidiEvents(nframes)
+// handleMidi matches one raw MIDI message against the mappings and queues
+// the resulting actions. It runs in the JACK thread and must not block.
+func handleMidi(cfg *Config, buf []byte, hr hiRes) {
+	if len(buf) == 0 {
+		return
+	}
+	if cfg.Clock != nil && buf[0] >= 0xF8 {
+		handleClock(cfg, buf[0])
+		return
+	}
+	if len(buf) < 2 {
+		return
+	}
+	if cfg.MTC != nil && (buf[0] == 0xF1 || isMTCFullFrame(buf)) {
+		handleMTC(cfg, buf)
+		return
+	}
 
-	if cfg == nil {
-		// Ne pas logger ici pour ne pas bloquer JACK
-		return 0
+	if len(buf) >= 6 && buf[0] == 0xF0 && buf[1] == 0x7F && buf[3] == 0x06 {
+		handleMMC(cfg, buf)
 	}
 
-	for _, event := range events {
-		// Ne jamais bloquer dans le thread JACK :
+	if isIdentityReply(buf) {
 		select {
-		case ch <- fmt.Sprintf("%#v", event):
+		case identityReplies <- buf:
 		default:
-			// Si le chan est plein, on saute sans bloquer
 		}
+	}
 
-		if event.Buffer[0]&0xF0 == 0xB0 { // CC
-			cc := event.Buffer[1]
-			val := event.Buffer[2]
+	if buf[0] == 0xF0 {
+		for i := range cfg.Mappings {
+			m := &cfg.Mappings[i]
+			if m.SysEx != nil && m.SysEx.match(buf) {
+				dispatch(MidiEvent{
+					Data:    buf,
+					Mapping: m,
+					Target:  m.targets(),
+					Actions: m.Actions,
+				})
+			}
+		}
+		return
+	}
 
-			for _, m := range cfg.Mappings {
-				if m.CC == cc && m.Value == val {
-					// Préparer une action à exécuter en dehors du thread JACK
-					msg := MidiEvent{
-						CC:      cc,
-						Value:   val,
-						Target:  cfg.OscTarget,
-						Actions: m.Actions,
-					}
+	channel := buf[0]&0x0F + 1
+	if cfg.Banks != nil && cfg.Banks.handle(channel, buf) {
+		return
+	}
+	if cfg.MPE != nil {
+		handleMPE(cfg, channel, buf)
+	}
 
-					select {
-					case eventChan <- msg:
-					default:
-						// Si le chan est plein, on ignore pour préserver le temps réel
+	switch buf[0] & 0xF0 {
+	case 0xB0: // CC
+		if len(buf) < 3 {
+			return
+		}
+		cc := buf[1]
+		val := buf[2]
+
+		if cc < 64 {
+			handleCC14(cfg, channel, cc, val)
+		}
+		switch cc {
+		case 6, 38, 98, 99, 100, 101:
+			handleParam(cfg, channel, cc, val)
+		}
+		prev, hasPrev := ccValue(channel, cc)
+		pickedUp := pickup(channel, cc, val)
+
+		for i := range cfg.Mappings {
+			m := &cfg.Mappings[i]
+			if !m.matchChannel(channel) || m.CC14 {
+				continue
+			}
+			if len(m.Combine) > 0 {
+				if slices.Contains(m.Combine, MidiNumber(cc)) {
+					values := make([]int, len(m.Combine))
+					for j, c := range m.Combine {
+						v, _ := ccValue(channel, uint8(c))
+						values[j] = int(v)
 					}
+					dispatch(MidiEvent{
+						Channel: channel,
+						CC:      cc,
+						Value:   int64(val),
+						Values:  values,
+						Range:   m.Range,
+						Mapping: m,
+						Target:  m.targets(),
+						Actions: m.Actions,
+					})
 				}
+				continue
+			}
+			if m.EncoderMode != "" {
+				if m.CC != nil && uint8(*m.CC) == cc {
+					handleEncoder(i, m, channel, cc, val)
+				}
+				continue
+			}
+			// Without a value the mapping fires on every CC change
+			if m.CC != nil && uint8(*m.CC) == cc && m.matchValue(val, true) && (pickedUp || !m.Pickup) && m.crossed(prev, hasPrev, val) {
+				value, max := hr.or(int64(val), 127)
+				// Préparer une action à exécuter en dehors du thread JACK
+				dispatch(MidiEvent{
+					Channel: channel,
+					CC:      cc,
+					Value:   value,
+					Max:     max,
+					Range:   m.Range,
+					Mapping: m,
+					Target:  m.targets(),
+					Actions: m.Actions,
+				})
+			}
+		}
+	case 0x90, 0x80: // Note On/Off
+		if len(buf) < 3 {
+			return
+		}
+		note := buf[1]
+		vel := buf[2]
+		if buf[0]&0xF0 == 0x80 {
+			vel = 0 // Note Off is handled as a Note On with velocity 0
+			hr = hiRes{}
+		}
+		setNoteState(channel, note, vel)
+
+		for i := range cfg.Mappings {
+			m := &cfg.Mappings[i]
+			if (m.Note == nil && m.Notes == nil) || !m.matchNote(note) || m.PolyAftertouch || !m.matchChannel(channel) {
+				continue
+			}
+			// Without a value the mapping fires on every Note On
+			if m.matchValue(vel, vel > 0 || m.hasGestures()) {
+				value, max := hr.or(int64(vel), 127)
+				dispatch(MidiEvent{
+					Channel:  channel,
+					Note:     note,
+					Value:    value,
+					Max:      max,
+					Velocity: vel,
+					Range:    m.Range,
+					Mapping:  m,
+					Target:   m.targets(),
+					Actions:  m.Actions,
+				})
+			}
+		}
+	case 0xE0: // Pitch bend, 14 bits LSB first
+		if len(buf) < 3 {
+			return
+		}
+		bend, max := hr.or(int64(buf[1]&0x7F)|int64(buf[2]&0x7F)<<7, 16383)
+
+		for i := range cfg.Mappings {
+			m := &cfg.Mappings[i]
+			if !m.PitchBend || !m.matchChannel(channel) {
+				continue
 			}
+			dispatch(MidiEvent{
+				Channel: channel,
+				Bend:    scaleBend(bend, max, m.Range),
+				Mapping: m,
+				Target:  m.targets(),
+				Actions: m.Actions,
+			})
+		}
+	case 0xA0: // Poly key pressure
+		if len(buf) < 3 {
+			return
+		}
+		note := buf[1]
+		pressure := buf[2]
+
+		for i := range cfg.Mappings {
+			m := &cfg.Mappings[i]
+			// Without a note the mapping receives pressure for every key
+			if !m.PolyAftertouch || !m.matchNote(note) || !m.matchChannel(channel) {
+				continue
+			}
+			value, max := hr.or(int64(pressure), 127)
+			dispatch(MidiEvent{
+				Channel:  channel,
+				Note:     note,
+				Value:    value,
+				Max:      max,
+				Pressure: pressure,
+				Range:    m.Range,
+				Mapping:  m,
+				Target:   m.targets(),
+				Actions:  m.Actions,
+			})
+		}
+	case 0xD0: // Channel pressure
+		pressure := buf[1]
+
+		for i := range cfg.Mappings {
+			m := &cfg.Mappings[i]
+			if !m.Aftertouch || !m.matchChannel(channel) {
+				continue
+			}
+			value, max := hr.or(int64(pressure), 127)
+			dispatch(MidiEvent{
+				Channel:  channel,
+				Value:    value,
+				Max:      max,
+				Pressure: pressure,
+				Range:    m.Range,
+				Mapping:  m,
+				Target:   m.targets(),
+				Actions:  m.Actions,
+			})
 		}
 	}
+}
+
+// encoderPos holds the accumulated position of each relative encoder
+// mapping, indexed like the config mappings. Only accessed from the JACK thread.
+var encoderPos []int
+
+// encoderDelta decodes a relative encoder step.
+func encoderDelta(mode string, val uint8) int {
+	switch mode {
+	case "twos_complement":
+		if val >= 64 {
+			return int(val) - 128
+		}
+		return int(val)
+	case "binary_offset":
+		return int(val) - 64
+	case "sign_magnitude":
+		if val&0x40 != 0 {
+			return -int(val & 0x3F)
+		}
+		return int(val & 0x3F)
+	}
 	return 0
 }
 
+// handleEncoder applies a relative encoder step to the mapping accumulator
+// and forwards the new position, clamped to 0-127.
+func handleEncoder(i int, m *Mapping, channel, cc, val uint8) {
+	pos := encoderPos[i] + encoderDelta(m.EncoderMode, val)
+	pos = max(0, min(127, pos))
+	if pos == encoderPos[i] {
+		return
+	}
+	encoderPos[i] = pos
+
+	dispatch(MidiEvent{
+		Channel: channel,
+		CC:      cc,
+		Value:   int64(pos),
+		Range:   m.Range,
+		Mapping: m,
+		Target:  m.targets(),
+		Actions: m.Actions,
+	})
+}
+
+type mpeNote struct {
+	id     int
+	note   uint8
+	active bool
+}
+
+// mpeNotes tracks the sounding note of each member channel. Only accessed
+// from the JACK thread.
+var (
+	mpeNotes  [16]mpeNote
+	mpeNextID int
+)
+
+// handleMPE translates note, pitch bend, CC74 and channel pressure on MPE
+// member channels into per-note messages under <path>/<id>/.
+func handleMPE(cfg *Config, channel uint8, buf []byte) {
+	mpe := cfg.MPE
+	master := uint8(mpe.Master)
+	if master == 0 {
+		master = 1
+	}
+	if channel == master || len(buf) < 2 {
+		return
+	}
+	prefix := mpe.Path
+	if prefix == "" {
+		prefix = "/note"
+	}
+	bendRange := mpe.BendRange
+	if bendRange == 0 {
+		bendRange = 48
+	}
+
+	n := &mpeNotes[channel-1]
+	var actions []OSCAction
+	send := func(name string, val float64) {
+		actions = append(actions, OSCAction{
+			Path:  fmt.Sprintf("%s/%d/%s", prefix, n.id, name),
+			Type:  "f",
+			Value: val,
+		})
+	}
+
+	status := buf[0] & 0xF0
+	switch {
+	case status == 0x90 && len(buf) >= 3 && buf[2] > 0:
+		mpeNextID++
+		*n = mpeNote{id: mpeNextID, note: buf[1], active: true}
+		send("pitch", float64(n.note))
+		send("on", float64(buf[2])/127)
+	case (status == 0x80 || status == 0x90) && len(buf) >= 3:
+		if !n.active || n.note != buf[1] {
+			return
+		}
+		n.active = false
+		vel := 0.0
+		if status == 0x80 {
+			vel = float64(buf[2]) / 127
+		}
+		send("off", vel)
+	case status == 0xE0 && len(buf) >= 3 && n.active:
+		bend := int(buf[1]&0x7F) | int(buf[2]&0x7F)<<7
+		send("pitch", float64(n.note)+scaleBend(int64(bend), 16383, [2]float64{-bendRange, bendRange}))
+	case status == 0xB0 && len(buf) >= 3 && buf[1] == 74 && n.active:
+		send("timbre", float64(buf[2])/127)
+	case status == 0xD0 && n.active:
+		send("pressure", float64(buf[1])/127)
+	}
+
+	if len(actions) > 0 {
+		// The messages of a note share a bundle and its timetag
+		dispatch(MidiEvent{
+			Channel: channel,
+			Note:    n.note,
+			Target:  cfg.OscTarget,
+			Actions: actions,
+			bundled: true,
+		})
+	}
+}
+
+// Clock state, only accessed from the JACK thread.
+var (
+	clockTicks   int
+	clockBeat    time.Time
+	clockBPM     float64
+	clockSentBPM float64
+)
+
+// handleClock measures the tempo over each beat (24 clock ticks), smooths
+// it and forwards it when it changes by at least 0.1 BPM.
+func handleClock(cfg *Config, status byte) {
+	clock := cfg.Clock
+	transport := func(path string) {
+		if path == "" {
+			return
+		}
+		dispatch(MidiEvent{
+			Target:  cfg.OscTarget,
+			Actions: []OSCAction{{Path: path, Type: "T"}},
+		})
+	}
+
+	switch status {
+	case 0xFA: // Start
+		clockTicks = 0
+		clockBeat = time.Time{}
+		transport(clock.StartPath)
+	case 0xFB: // Continue
+		transport(clock.ContinuePath)
+	case 0xFC: // Stop
+		transport(clock.StopPath)
+	case 0xF8: // Clock
+		now := time.Now()
+		if clockBeat.IsZero() {
+			clockBeat = now
+			return
+		}
+		clockTicks++
+		if clockTicks < 24 {
+			return
+		}
+		bpm := 60 / now.Sub(clockBeat).Seconds()
+		clockTicks = 0
+		clockBeat = now
+		if clockBPM == 0 {
+			clockBPM = bpm
+		} else {
+			clockBPM += 0.3 * (bpm - clockBPM)
+		}
+
+		rounded := math.Round(clockBPM*10) / 10
+		if rounded == clockSentBPM {
+			return
+		}
+		clockSentBPM = rounded
+		path := clock.Path
+		if path == "" {
+			path = "/tempo"
+		}
+		dispatch(MidiEvent{
+			Target:  cfg.OscTarget,
+			Actions: []OSCAction{{Path: path, Type: "f", Value: rounded}},
+		})
+	}
+}
+
+var mmcCommands = map[byte]string{
+	0x01: "stop",
+	0x02: "play",
+	0x03: "deferred_play",
+	0x04: "fast_forward",
+	0x05: "rewind",
+	0x06: "record",
+	0x07: "record_exit",
+	0x08: "record_pause",
+	0x09: "pause",
+	0x0A: "eject",
+	0x0B: "chase",
+	0x0D: "reset",
+	0x44: "locate",
+}
+
+// handleMMC decodes MIDI Machine Control commands (F0 7F <device> 06 <cmd>)
+// for mmc mappings. The locate target is available as $locate.
+func handleMMC(cfg *Config, buf []byte) {
+	name, ok := mmcCommands[buf[4]]
+	if !ok {
+		return
+	}
+	var locate string
+	// F0 7F <device> 06 44 06 01 hh mm ss ff sf F7
+	if name == "locate" && len(buf) >= 12 && buf[6] == 0x01 {
+		locate = fmt.Sprintf("%02d:%02d:%02d:%02d", buf[7]&0x1F, buf[8], buf[9], buf[10])
+	}
+
+	for i := range cfg.Mappings {
+		m := &cfg.Mappings[i]
+		if m.MMC != name {
+			continue
+		}
+		dispatch(MidiEvent{
+			Locate:  locate,
+			Data:    buf,
+			Mapping: m,
+			Target:  m.targets(),
+			Actions: m.Actions,
+		})
+	}
+}
+
+var mtcRates = [4]int{24, 25, 30, 30} // 29.97 drop frame counts like 30
+
+// MTC state, only accessed from the JACK thread.
+var (
+	mtcPieces   [8]uint8
+	mtcReceived uint8 // bitmask of quarter frames received
+)
+
+func isMTCFullFrame(buf []byte) bool {
+	return len(buf) == 10 && buf[0] == 0xF0 && buf[1] == 0x7F && buf[3] == 0x01 && buf[4] == 0x01
+}
+
+// handleMTC assembles quarter frames (0xF1) and full frame SysEx messages
+// into SMPTE timecode.
+func handleMTC(cfg *Config, buf []byte) {
+	var hh, mm, ss, ff, rate int
+	if buf[0] == 0xF0 {
+		rate = int(buf[5]>>5) & 0x03
+		hh, mm, ss, ff = int(buf[5]&0x1F), int(buf[6]), int(buf[7]), int(buf[8])
+	} else {
+		piece := buf[1] >> 4 & 0x07
+		mtcPieces[piece] = buf[1] & 0x0F
+		mtcReceived |= 1 << piece
+		// A full timecode is available once the last quarter frame arrives
+		if piece != 7 || mtcReceived != 0xFF {
+			return
+		}
+		mtcReceived = 0
+		p := mtcPieces
+		ff = int(p[0] | p[1]<<4)
+		ss = int(p[2] | p[3]<<4)
+		mm = int(p[4] | p[5]<<4)
+		hh = int(p[6] | (p[7]&0x01)<<4)
+		rate = int(p[7]>>1) & 0x03
+
+		// The 8 quarter frames span 2 frames: the decoded time is 2 frames late
+		ff += 2
+		fps := mtcRates[rate]
+		if ff >= fps {
+			ff -= fps
+			if ss++; ss == 60 {
+				ss = 0
+				if mm++; mm == 60 {
+					mm = 0
+					hh = (hh + 1) % 24
+				}
+			}
+		}
+	}
+
+	path := cfg.MTC.Path
+	if path == "" {
+		path = "/timecode"
+	}
+	dispatch(MidiEvent{
+		Target: cfg.OscTarget,
+		Actions: []OSCAction{{
+			Path:  path,
+			Type:  "s",
+			Value: fmt.Sprintf("%02d:%02d:%02d:%02d", hh, mm, ss, ff),
+		}},
+	})
+}
+
+type cc14State struct {
+	msb    uint8
+	at     time.Time
+	paired bool // the controller is known to send LSBs for this pair
+}
+
+// cc14Pairs is only accessed from the JACK thread.
+var cc14Pairs [16][32]cc14State
+
+// handleCC14 combines CC n (MSB) and CC n+32 (LSB) into a 14-bit value for
+// cc14 mappings. Until a LSB has been seen the MSB alone is forwarded, so
+// controllers that only send coarse values still work.
+func handleCC14(cfg *Config, channel, cc, val uint8) {
+	timeout := 50 * time.Millisecond
+	if cfg.CC14TimeoutMs > 0 {
+		timeout = time.Duration(cfg.CC14TimeoutMs) * time.Millisecond
+	}
+
+	st := &cc14Pairs[channel-1][cc%32]
+	var value int
+	if cc < 32 {
+		st.msb = val
+		st.at = time.Now()
+		if st.paired {
+			return
+		}
+		value = int(val) << 7
+	} else {
+		if time.Since(st.at) > timeout {
+			return
+		}
+		st.paired = true
+		value = int(st.msb)<<7 | int(val)
+	}
+
+	for i := range cfg.Mappings {
+		m := &cfg.Mappings[i]
+		if !m.CC14 || m.CC == nil || uint8(*m.CC) != cc%32 || !m.matchChannel(channel) {
+			continue
+		}
+		dispatch(MidiEvent{
+			Channel: channel,
+			CC:      cc % 32,
+			Value:   int64(value),
+			Max:     16383,
+			Range:   m.Range,
+			Mapping: m,
+			Target:  m.targets(),
+			Actions: m.Actions,
+		})
+	}
+}
+
+type paramState struct {
+	rpn     bool
+	num     [2]uint8 // MSB, LSB of the selected parameter, 127/127 is the null parameter
+	dataMSB uint8
+	paired  bool
+}
+
+// nullParam deselects the parameter, data entry being ignored until the
+// next selection.
+var nullParam = [2]uint8{127, 127}
+
+// params is only accessed from the JACK thread. No parameter is selected
+// at startup.
+var params = func() (p [16]paramState) {
+	for i := range p {
+		p[i].num = nullParam
+	}
+	return p
+}()
+
+// handleParam decodes NRPN (CC 99/98) and RPN (CC 101/100) parameter
+// selection followed by data entry (CC 6/38) for nrpn and rpn mappings.
+func handleParam(cfg *Config, channel, cc, val uint8) {
+	st := &params[channel-1]
+	switch cc {
+	case 99, 101:
+		st.rpn = cc == 101
+		st.num[0] = val
+		st.paired = false
+		return
+	case 98, 100:
+		st.rpn = cc == 100
+		st.num[1] = val
+		st.paired = false
+		return
+	}
+
+	if st.num == nullParam {
+		return
+	}
+	var value int
+	if cc == 6 {
+		st.dataMSB = val
+		if st.paired {
+			return
+		}
+		value = int(val) << 7
+	} else {
+		st.paired = true
+		value = int(st.dataMSB)<<7 | int(val)
+	}
+
+	num := uint16(st.num[0])<<7 | uint16(st.num[1])
+	for i := range cfg.Mappings {
+		m := &cfg.Mappings[i]
+		p := m.NRPN
+		if st.rpn {
+			p = m.RPN
+		}
+		if p == nil || uint16(*p) != num || !m.matchChannel(channel) {
+			continue
+		}
+		dispatch(MidiEvent{
+			Channel: channel,
+			Value:   int64(value),
+			Max:     16383,
+			Range:   m.Range,
+			Mapping: m,
+			Target:  m.targets(),
+			Actions: m.Actions,
+		})
+		if stopMatching {
+			return
+		}
+	}
+}
+
+func (m Mapping) targets() TargetList {
+	if len(m.OscTarget) > 0 {
+		return m.OscTarget
+	}
+	return activeConfig.Load().OscTarget
+}
+
+func (m Mapping) matchChannel(channel uint8) bool {
+	return m.Channel == nil || uint8(*m.Channel) == channel
+}
+
+// matchNote reports whether note is the mapping note or inside its zone.
+// A mapping without note constraints matches every note.
+func (m Mapping) matchNote(note uint8) bool {
+	if m.Note != nil && uint8(*m.Note) != note {
+		return false
+	}
+	if m.Notes != nil && (note < m.Notes.Min || note > m.Notes.Max) {
+		return false
+	}
+	return true
+}
+
+// stops reports whether no other mapping may fire after this one.
+func (m *Mapping) stops() bool {
+	if m.Stop != nil {
+		return *m.Stop
+	}
+	return activeConfig.Load().Match == "first"
+}
+
+// matchValue reports whether val satisfies the mapping value constraints,
+// falling back to dflt when the mapping doesn't specify any.
+func (m Mapping) matchValue(val uint8, dflt bool) bool {
+	if m.Value == nil && m.ValueMin == nil && m.ValueMax == nil {
+		return dflt
+	}
+	if m.Value != nil && (val < m.Value.Min || val > m.Value.Max) {
+		return false
+	}
+	if m.ValueMin != nil && val < uint8(*m.ValueMin) {
+		return false
+	}
+	if m.ValueMax != nil && val > uint8(*m.ValueMax) {
+		return false
+	}
+	return true
+}
+
+// scaleBend maps a pitch bend value with the given full scale (16383 for
+// MIDI 1.0) onto rng, keeping the center of the wheel at the middle of the
+// range.
+func scaleBend(bend, max int64, rng [2]float64) float64 {
+	lo, hi := rng[0], rng[1]
+	if lo == 0 && hi == 0 {
+		lo, hi = -1.0, 1.0
+	}
+	center := (max + 1) / 2
+	var pos float64 // -1.0..1.0
+	if bend >= center {
+		pos = float64(bend-center) / float64(max-center)
+	} else {
+		pos = float64(bend-center) / float64(center)
+	}
+	return lo + (pos+1)/2*(hi-lo)
+}
+
+func dispatch(msg MidiEvent) {
+	if m := msg.Mapping; m != nil {
+		if stopMatching || !m.enabled() || !m.inBank() || !m.inProfile() || !m.onPort() || m.When != nil && !m.When.holds() || m.inDeadzone(msg) {
+			return
+		}
+		stopMatching = m.stops()
+		if m.Mode == "toggle" {
+			actions, ok := m.toggle(msg.Value)
+			if !ok {
+				return
+			}
+			msg.Actions = actions
+		}
+		if m.hasGestures() {
+			m.gesture(msg)
+			return
+		}
+	}
+	select {
+	case eventChan <- msg:
+	default:
+		// Si le chan est plein, on ignore pour préserver le temps réel
+	}
+}
+
 func main() {
 
 	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug}))
 	slog.SetDefault(logger)
 
+	dump := false
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		case "schema":
+			os.Exit(runSchema())
+		case "list-presets":
+			os.Exit(runListPresets())
+		case "convert":
+			os.Exit(runConvert(os.Args[2:]))
+		case "dump-config":
+			// Loads the config with the usual flags, logging to stderr
+			dump = true
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+			slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, nil)))
+		}
+	}
+
 	var err error
-	cfgPath := flag.String("config", "", "Path to YAML config")
+	flag.StringVar(&configPath, "config", "", "Config file or directory, - for stdin or an http(s) URL")
+	configDir := flag.String("config-dir", "", "Directory of YAML configs, merged in name order")
+	preset := flag.String("preset", "", "Built-in config to run, see list-presets")
+	flag.BoolVar(&useUMP, "ump", false, "Receive MIDI 2.0 Universal MIDI Packets (PipeWire only)")
+	flag.StringVar(&clientNameFlag, "client-name", "", "JACK client name, replacing client_name of the config, to run several instances")
+	play := flag.String("play", "", "MIDI file played instead of a MIDI input, exiting at its end")
+	noTiming := flag.Bool("no-timing", false, "With -play, send the events as fast as possible")
+	inject := flag.String("inject", "", "Hex MIDI messages such as \"B0 14 7F\", one line each, read from a named pipe or - for stdin instead of a MIDI input")
+	input := flag.String("input", defaultInput(), "MIDI backend: "+inputUsage())
+	flag.StringVar(&inputPort, "input-port", "", "Part of the name of the MIDI ports (rtmidi, pipewire) or BLE device (ble) to use, virtual rtmidi ports if empty")
+	flag.IntVar(&rtpMidiPort, "rtpmidi-port", rtpMidiPort, "RTP-MIDI control port, data on the next one")
+	flag.StringVar(&rtpMidiConnect, "rtpmidi-connect", "", "host:port of an RTP-MIDI session to join instead of waiting for one")
+	flag.Var(&connectFlag, "connect", "MIDI output to connect when it appears (JACK or ALSA), regexp of its full name or device=regexp (repeatable)")
+	discover := flag.Bool("discover", false, "List OSC services announced with mDNS and exit")
+	disableTags := flag.String("disable-tags", "", "Comma separated tags of mappings to disable")
+	oscTarget := flag.String("osc-target", "", "OSC target replacing osc_target of the config")
+	profile := flag.String("profile", "", "Profile active at startup, replacing profile of the config")
+	flag.StringVar(&controllerFlag, "controller", "", "Built-in controller ("+strings.Join(builtinControllers(), ", ")+"), definition file or auto, replacing controller of the config")
+	flag.BoolVar(&strictLoad, "strict", false, "Fail on mappings duplicating another one instead of warning")
+	flag.Var(&overrides, "set", "Override a config value, e.g. mappings[0].actions[0].value=1 (repeatable)")
 	flag.Parse()
 
-	if *cfgPath == "" {
-		err := yaml.Unmarshal([]byte(resources.MidiMappingYaml), &cfg)
+	if *oscTarget != "" {
+		overrides = append(overrideFlags{"osc_target=" + *oscTarget}, overrides...)
+	}
+
+	if *configDir != "" {
+		if configPath != "" {
+			log.Fatal("-config and -config-dir are exclusive, include the directory from the config instead")
+		}
+		configPath = *configDir
+	}
+
+	if nsmURL := os.Getenv("NSM_URL"); nsmURL != "" && !dump {
+		path, id, err := joinNSM(nsmURL)
+		if err != nil {
+			slog.Error("Failed to join NSM session", slog.Any("err", err))
+			os.Exit(1)
+		}
+		configPath, clientNameFlag = path, id
+	}
+
+	for _, tag := range strings.Split(*disableTags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			enableTag(tag, false)
+		}
+	}
+
+	if *discover {
+		if err := discoverServices(3 * time.Second); err != nil {
+			slog.Error("mDNS discovery failed", slog.Any("err", err))
+			os.Exit(1)
+		}
+		return
+	}
+
+	var cfg *Config
+	switch {
+	case *preset != "":
+		if configPath != "" {
+			log.Fatal("-preset replaces the config, use -set or -osc-target to adjust it")
+		}
+		configSource = func() (*Config, error) { return loadPreset(*preset) }
+		cfg, err = configSource()
+		if err != nil {
+			slog.Error("Failed to load preset", slog.Any("err", err))
+			os.Exit(1)
+		}
+		slog.Info("Loaded preset", slog.String("preset", *preset), slog.Any("osc_target", cfg.OscTarget))
+	case configPath == "":
+		configSource = func() (*Config, error) { return parseConfig([]byte(resources.MidiMappingYaml)) }
+		cfg, err = configSource()
 		if err != nil {
 			slog.Error("Failed to parse embedded config", slog.Any("err", err))
 			os.Exit(1)
 		}
-		slog.Info("Loaded embedded config", slog.String("osc_target", cfg.OscTarget))
-	} else {
-		cfg, err = loadConfig(*cfgPath)
+		slog.Info("Loaded embedded config", slog.Any("osc_target", cfg.OscTarget))
+	default:
+		configSource = func() (*Config, error) { return loadConfig(configPath) }
+		cfg, err = configSource()
 		if err != nil {
-			slog.Error("Failed to load config", slog.String("file", *cfgPath), slog.Any("err", err))
+			slog.Error("Failed to load config", slog.String("file", configPath), slog.Any("err", err))
 			os.Exit(1)
 		}
-		slog.Info("Loaded config", slog.String("osc_target", cfg.OscTarget))
+		slog.Info("Loaded config", slog.Any("osc_target", cfg.OscTarget))
 	}
-
-	client, status := jack.ClientOpen("midi2osc", jack.NoStartServer)
-	if client == nil || status != 0 {
-		log.Fatalf("Failed to open JACK client: status %d", status)
+	if err := checkDuplicates(cfg); err != nil {
+		slog.Error("Invalid config", slog.Any("err", err))
+		os.Exit(1)
+	}
+	if *profile != "" {
+		if _, ok := cfg.Profiles[*profile]; !ok {
+			slog.Error("Unknown profile", slog.String("profile", *profile))
+			os.Exit(1)
+		}
+		selectedProfile.Store(*profile)
+	}
+	if dump {
+		if err := dumpConfig(cfg); err != nil {
+			slog.Error("Failed to dump config", slog.Any("err", err))
+			os.Exit(1)
+		}
+		return
+	}
+	encoderPos = make([]int, len(cfg.Mappings))
+	initVariables(cfg)
+	activeConfig.Store(cfg)
+	if configPath != "" {
+		watchConfig(cfg.files)
 	}
-	defer client.Close()
 
-	portIn = client.PortRegister("midi_in", jack.DEFAULT_MIDI_TYPE, jack.PortIsInput, 0)
-	if portIn == nil {
-		log.Fatal("Failed to register MIDI input port")
+	var in midiInput
+	switch {
+	case *play != "":
+		if in, err = openPlayer(*play, *noTiming); err != nil {
+			log.Fatalf("Failed to open MIDI file: %v", err)
+		}
+	case *inject != "":
+		if in, err = openInjector(*inject); err != nil {
+			log.Fatalf("Failed to open MIDI injection: %v", err)
+		}
+	default:
+		open, ok := inputs[*input]
+		if !ok {
+			log.Fatalf("Unknown input %q, built in: %s", *input, inputUsage())
+		}
+		if useUMP && *input != "jack" {
+			log.Fatal("-ump needs the jack input")
+		}
+		if in, err = open(cfg.clientName()); err != nil {
+			log.Fatalf("Failed to open %s input: %v", *input, err)
+		}
 	}
-	slog.Info("Registered MIDI input port", slog.String("name", portIn.GetName()))
+	defer in.close()
 
 	eventChan = make(chan MidiEvent, 64) // global
 	ch = make(chan string, 64)
@@ -177,38 +1760,77 @@ func main() {
 			slog.Debug("Raw MIDI", "event", line)
 		}
 	}()
+	go identifyDevices()
 	go func() {
 		for msg := range eventChan {
-			for _, act := range msg.Actions {
-				err := sendOSC(msg.Target, act.Path, act.Type, act.Value)
-				if err != nil {
-					slog.Error("Failed to send OSC", slog.String("path", act.Path), slog.Any("err", err))
-				} else {
-					slog.Info("OSC sent", slog.String("path", act.Path), slog.Any("val", act.Value))
-				}
+			if msg.flushed != nil {
+				close(msg.flushed)
+				continue
+			}
+			if !muted.Load() && throttle(msg) && ramp(msg) {
+				sendEvent(msg)
 			}
 		}
 	}()
 
-	if code := client.SetProcessCallback(process); code != 0 {
-		slog.Error("Failed to set process callback:", slog.Any("err", jack.StrError(code)))
-		return
+	if cfg.Feedback != nil {
+		if err := startFeedback(cfg.Feedback); err != nil {
+			slog.Error("Failed to start OSC feedback server", slog.String("listen", cfg.Feedback.Listen), slog.Any("err", err))
+			os.Exit(1)
+		}
 	}
-	client.OnShutdown(func() {
-		close(ch)
-	})
 
-	if code := client.Activate(); code != 0 {
-		slog.Error("Failed to activate JACK client", slog.Any("err", jack.StrError(code)))
+	if cfg.OSCQuery != nil {
+		if err := startOSCQuery(cfg.OSCQuery); err != nil {
+			slog.Error("Failed to start OSCQuery server", slog.String("listen", cfg.OSCQuery.Listen), slog.Any("err", err))
+			os.Exit(1)
+		}
+	}
+
+	if cfg.Metrics != nil {
+		if err := startMetrics(cfg.Metrics); err != nil {
+			slog.Error("Failed to start metrics server", slog.String("listen", cfg.Metrics.Listen), slog.Any("err", err))
+			os.Exit(1)
+		}
+	}
+
+	if cfg.WebMIDI != nil {
+		if err := startWebMIDI(cfg.WebMIDI); err != nil {
+			slog.Error("Failed to start WebMIDI server", slog.String("listen", cfg.WebMIDI.Listen), slog.Any("err", err))
+			os.Exit(1)
+		}
+	}
+
+	if err := in.start(); err != nil {
+		slog.Error("Failed to start MIDI input", slog.String("input", *input), slog.Any("err", err))
 		return
 	}
-	slog.Info("JACK client active", slog.String("name", client.GetName()))
+	if _, ok := in.(realtimeInput); !ok {
+		go serveRemote()
+	}
+	requestIdentity()
+	if len(cfg.OnStart) > 0 {
+		dispatch(MidiEvent{Target: cfg.OscTarget, Actions: cfg.OnStart})
+	}
+	startTimers(cfg)
 
 	// Wait for Ctrl+C
-	str, more := "", true
-	for more {
-		str, more = <-ch
-		fmt.Printf("Midi Event: %s\n", str)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	for more := true; more; {
+		select {
+		case str := <-ch:
+			fmt.Printf("Midi Event: %s\n", str)
+		case <-inputEnded:
+			more = false
+		case <-sigs:
+			more = false
+		}
+	}
+	if cfg = activeConfig.Load(); len(cfg.OnExit) > 0 {
+		// Sent directly, the sender goroutine may be busy
+		sendEvent(MidiEvent{Target: cfg.OscTarget, Actions: cfg.OnExit})
 	}
+	closeMQTT()
 	slog.Info("Exiting...")
 }
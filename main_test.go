@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseMidiNumber(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    uint64
+		wantErr bool
+	}{
+		{in: "0", want: 0},
+		{in: "127", want: 127},
+		{in: " 64 ", want: 64},
+		{in: "010", want: 10}, // not octal
+		{in: "0x0B", want: 11},
+		{in: "0X7f", want: 127},
+		{in: "0x00", want: 0},
+		{in: "128", wantErr: true},
+		{in: "0x80", wantErr: true},
+		{in: "-1", wantErr: true},
+		{in: "0x", wantErr: true},
+		{in: "0b101", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseMidiNumber(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseMidiNumber(%q) = %d, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("parseMidiNumber(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestValueRange(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ValueRange
+		wantErr bool
+	}{
+		{in: "64", want: ValueRange{64, 64}},
+		{in: "0x7F", want: ValueRange{127, 127}},
+		{in: "64-127", want: ValueRange{64, 127}},
+		{in: "0x40-0x7F", want: ValueRange{64, 127}},
+		{in: "'>0'", want: ValueRange{1, 127}},
+		{in: "'>=64'", want: ValueRange{64, 127}},
+		{in: "'<64'", want: ValueRange{0, 63}},
+		{in: "'<=0x3F'", want: ValueRange{0, 63}},
+		{in: "'==0'", want: ValueRange{0, 0}},
+		{in: "127-0", wantErr: true},
+		{in: "0-128", wantErr: true},
+		{in: "'>127'", wantErr: true},
+		{in: "'<0'", wantErr: true},
+		{in: "high", wantErr: true},
+	}
+	for _, tt := range tests {
+		var got ValueRange
+		err := yaml.Unmarshal([]byte(tt.in), &got)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ValueRange %s = %v, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ValueRange %s = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestMidiNumber(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    MidiNumber
+		wantErr bool
+	}{
+		{in: "11", want: 11},
+		{in: "0x0B", want: 11},
+		{in: `"0x0B"`, want: 11},
+		{in: `"11"`, want: 11},
+		{in: "0x7F", want: 127},
+		{in: "128", wantErr: true},
+		{in: "-1", wantErr: true},
+		{in: "1.5", wantErr: true},
+		{in: `"volume"`, wantErr: true},
+	}
+	for _, tt := range tests {
+		var m Mapping
+		err := yaml.Unmarshal([]byte("cc: "+tt.in), &m)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("YAML cc: %s = %d, want an error", tt.in, *m.CC)
+			}
+		} else if err != nil || m.CC == nil || *m.CC != tt.want {
+			t.Errorf("YAML cc: %s = %v, %v, want %d", tt.in, m.CC, err, tt.want)
+		}
+
+		// JSON configs are read as YAML
+		if !json.Valid([]byte(tt.in)) {
+			continue // 0x0B is YAML only
+		}
+		m = Mapping{}
+		err = yaml.Unmarshal([]byte(`{"cc": `+tt.in+`}`), &m)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf(`JSON {"cc": %s} = %d, want an error`, tt.in, *m.CC)
+			}
+		} else if err != nil || m.CC == nil || *m.CC != tt.want {
+			t.Errorf(`JSON {"cc": %s} = %v, %v, want %d`, tt.in, m.CC, err, tt.want)
+		}
+	}
+}
+
+func TestJSONConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	config := `{
+  "osc_target": "localhost:9000",
+  "mappings": [
+    {"cc": "0x0B", "channel": "0x02", "actions": [{"path": "/expression", "type": "f", "value": "$midi"}]},
+    {"nrpn": "0x100", "actions": [{"path": "/param", "type": "i", "value": "$midi"}]}
+  ]
+}`
+	if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m0, m1 := c.Mappings[0], c.Mappings[1]
+	if *m0.CC != 11 || *m0.Channel != 2 || *m1.NRPN != 256 {
+		t.Errorf("cc %d, channel %d, nrpn %d, want 11, 2 and 256", *m0.CC, *m0.Channel, *m1.NRPN)
+	}
+}
+
+func TestParamNumber(t *testing.T) {
+	var m Mapping
+	if err := yaml.Unmarshal([]byte("nrpn: 0x3FFF\nrpn: '16'"), &m); err != nil {
+		t.Fatal(err)
+	}
+	if *m.NRPN != 16383 || *m.RPN != 16 {
+		t.Errorf("nrpn %d, rpn %d, want 16383 and 16", *m.NRPN, *m.RPN)
+	}
+	if err := yaml.Unmarshal([]byte("nrpn: 16384"), &m); err == nil {
+		t.Error("nrpn 16384 accepted")
+	}
+}
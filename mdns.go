@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+const (
+	mdnsTimeout  = 2 * time.Second
+	mdnsCacheTTL = 30 * time.Second
+)
+
+var oscServices = []string{"_osc._udp", "_osc._tcp"}
+
+type mdnsEntry struct {
+	target  string
+	expires time.Time
+}
+
+var (
+	mdnsCacheMu sync.Mutex
+	mdnsCache   = map[string]mdnsEntry{}
+)
+
+// resolveService returns a concrete target such as "osc.udp://ip:port" for
+// an mDNS/DNS-SD instance name. Results are cached for a short time so
+// address changes are picked up.
+func resolveService(name string) (string, error) {
+	mdnsCacheMu.Lock()
+	e, ok := mdnsCache[name]
+	mdnsCacheMu.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		return e.target, nil
+	}
+
+	for _, service := range oscServices {
+		entry, err := lookupService(name, service)
+		if err != nil {
+			return "", err
+		}
+		if entry == nil {
+			continue
+		}
+		target := serviceTarget(entry)
+		slog.Info("Resolved OSC service", slog.String("name", name), slog.String("target", target))
+		mdnsCacheMu.Lock()
+		mdnsCache[name] = mdnsEntry{target: target, expires: time.Now().Add(mdnsCacheTTL)}
+		mdnsCacheMu.Unlock()
+		return target, nil
+	}
+	return "", fmt.Errorf("OSC service %q not found", name)
+}
+
+// forgetService drops a cached resolution, e.g. after a failed send.
+func forgetService(name string) {
+	mdnsCacheMu.Lock()
+	delete(mdnsCache, name)
+	mdnsCacheMu.Unlock()
+}
+
+func lookupService(name, service string) (*zeroconf.ServiceEntry, error) {
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), mdnsTimeout)
+	defer cancel()
+
+	entries := make(chan *zeroconf.ServiceEntry)
+	if err := resolver.Lookup(ctx, name, service, "local.", entries); err != nil {
+		return nil, err
+	}
+	for {
+		select {
+		case entry, ok := <-entries:
+			if !ok { // closed by the resolver when ctx ends
+				return nil, nil
+			}
+			if len(entry.AddrIPv4) > 0 || len(entry.AddrIPv6) > 0 {
+				return entry, nil
+			}
+		case <-ctx.Done():
+			return nil, nil
+		}
+	}
+}
+
+func serviceTarget(entry *zeroconf.ServiceEntry) string {
+	scheme := "osc.udp"
+	if entry.Service == "_osc._tcp" {
+		scheme = "osc.tcp"
+	}
+	ip := entry.AddrIPv6
+	if len(entry.AddrIPv4) > 0 {
+		ip = entry.AddrIPv4
+	}
+	return scheme + "://" + net.JoinHostPort(ip[0].String(), strconv.Itoa(entry.Port))
+}
+
+// discoverServices prints the OSC services announced on the local network.
+func discoverServices(wait time.Duration) error {
+	resolver, err := zeroconf.NewResolver(nil)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), wait)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, service := range oscServices {
+		entries := make(chan *zeroconf.ServiceEntry)
+		if err := resolver.Browse(ctx, service, "local.", entries); err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case entry, ok := <-entries:
+					if !ok {
+						return
+					}
+					if len(entry.AddrIPv4) > 0 || len(entry.AddrIPv6) > 0 {
+						fmt.Printf("mdns://%s\t%s\n", entry.Instance, serviceTarget(entry))
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
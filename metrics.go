@@ -0,0 +1,28 @@
+package main
+
+import (
+	"expvar"
+	"log/slog"
+	"net"
+	"net/http"
+)
+
+const defaultMetricsListen = "localhost:6060"
+
+// startMetrics serves the counters, such as osc_send_errors by error
+// class, as JSON on /debug/vars.
+func startMetrics(conf *MetricsConfig) error {
+	listen := conf.Listen
+	if listen == "" {
+		listen = defaultMetricsListen
+	}
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	slog.Info("Metrics server listening", slog.String("addr", ln.Addr().String()))
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	go http.Serve(ln, mux)
+	return nil
+}
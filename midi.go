@@ -0,0 +1,132 @@
+package main
+
+// midiParser splits a raw MIDI byte stream into complete messages. It
+// handles running status, real-time bytes interleaved with other messages
+// and SysEx, and drops truncated or stray data instead of mis-mapping it.
+// A parser keeps state between calls to feed and is not safe for
+// concurrent use.
+type midiParser struct {
+	running byte // running status, 0 when none
+	msg     []byte
+	want    int // expected length of msg, 0 for SysEx
+	sysex   bool
+}
+
+// feed parses data and calls emit for every complete message. The slice
+// passed to emit is owned by the callee.
+func (p *midiParser) feed(data []byte, emit func([]byte)) {
+	for _, b := range data {
+		switch {
+		case b >= 0xF8: // Real-time, may appear anywhere
+			emit([]byte{b})
+		case b == 0xF0:
+			p.running = 0
+			p.sysex = true
+			p.msg = append(p.msg[:0], b)
+			p.want = 0
+		case b == 0xF7:
+			if p.sysex {
+				emit(append(append([]byte(nil), p.msg...), b))
+			}
+			p.sysex = false
+			p.msg = p.msg[:0]
+		case b >= 0x80:
+			// Any other status byte aborts an unterminated SysEx or a
+			// truncated message
+			p.sysex = false
+			p.msg = append(p.msg[:0], b)
+			p.want = messageLen(b)
+			if b < 0xF0 {
+				p.running = b
+			} else {
+				p.running = 0 // system common messages cancel running status
+			}
+			if p.want == 1 {
+				p.flush(emit)
+			}
+		default: // Data byte
+			switch {
+			case p.sysex:
+				p.msg = append(p.msg, b)
+			case len(p.msg) > 0:
+				p.msg = append(p.msg, b)
+			case p.running != 0:
+				p.msg = append(p.msg[:0], p.running, b)
+				p.want = messageLen(p.running)
+			default:
+				continue // stray data byte
+			}
+			if !p.sysex && len(p.msg) == p.want {
+				p.flush(emit)
+			}
+		}
+	}
+}
+
+func (p *midiParser) flush(emit func([]byte)) {
+	emit(append([]byte(nil), p.msg...))
+	p.msg = p.msg[:0]
+}
+
+// messageLen returns the length of a non-SysEx message including its
+// status byte.
+func messageLen(status byte) int {
+	switch status & 0xF0 {
+	case 0xC0, 0xD0:
+		return 2
+	case 0xF0:
+		switch status {
+		case 0xF1, 0xF3:
+			return 2
+		case 0xF2:
+			return 3
+		}
+		return 1
+	}
+	return 3
+}
+
+// midiType names the kind of MIDI message starting with the status byte.
+func midiType(status byte) string {
+	switch status & 0xF0 {
+	case 0x80:
+		return "note_off"
+	case 0x90:
+		return "note_on"
+	case 0xA0:
+		return "poly_aftertouch"
+	case 0xB0:
+		return "cc"
+	case 0xC0:
+		return "program_change"
+	case 0xD0:
+		return "aftertouch"
+	case 0xE0:
+		return "pitchbend"
+	}
+	switch status {
+	case 0xF0:
+		return "sysex"
+	case 0xF1:
+		return "mtc"
+	case 0xF2:
+		return "song_position"
+	case 0xF3:
+		return "song_select"
+	case 0xF6:
+		return "tune_request"
+	case 0xF8:
+		return "clock"
+	case 0xFA:
+		return "start"
+	case 0xFB:
+		return "continue"
+	case 0xFC:
+		return "stop"
+	case 0xFE:
+		return "active_sensing"
+	case 0xFF:
+		return "reset"
+	}
+	return "unknown"
+}
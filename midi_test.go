@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMidiParser(t *testing.T) {
+	tests := []struct {
+		name  string
+		feeds [][]byte
+		want  [][]byte
+	}{
+		{
+			name:  "note on",
+			feeds: [][]byte{{0x90, 60, 100}},
+			want:  [][]byte{{0x90, 60, 100}},
+		},
+		{
+			name:  "running status",
+			feeds: [][]byte{{0xB0, 7, 10, 7, 20, 8, 30}},
+			want:  [][]byte{{0xB0, 7, 10}, {0xB0, 7, 20}, {0xB0, 8, 30}},
+		},
+		{
+			name:  "running status across buffers",
+			feeds: [][]byte{{0x90, 60}, {100, 62}, {90}},
+			want:  [][]byte{{0x90, 60, 100}, {0x90, 62, 90}},
+		},
+		{
+			name:  "two-byte messages",
+			feeds: [][]byte{{0xC1, 5, 6, 0xD0, 64}},
+			want:  [][]byte{{0xC1, 5}, {0xC1, 6}, {0xD0, 64}},
+		},
+		{
+			name:  "realtime inside a message",
+			feeds: [][]byte{{0x90, 0xF8, 60, 0xFE, 100}},
+			want:  [][]byte{{0xF8}, {0xFE}, {0x90, 60, 100}},
+		},
+		{
+			name:  "realtime keeps running status",
+			feeds: [][]byte{{0xB0, 1, 2, 0xF8, 1, 3}},
+			want:  [][]byte{{0xB0, 1, 2}, {0xF8}, {0xB0, 1, 3}},
+		},
+		{
+			name:  "sysex",
+			feeds: [][]byte{{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}},
+			want:  [][]byte{{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}},
+		},
+		{
+			name:  "sysex split across buffers",
+			feeds: [][]byte{{0xF0, 0x43}, {0x10, 0x4C}, {0x00, 0xF7}},
+			want:  [][]byte{{0xF0, 0x43, 0x10, 0x4C, 0x00, 0xF7}},
+		},
+		{
+			name:  "realtime inside sysex",
+			feeds: [][]byte{{0xF0, 0x43, 0xF8}, {0x10, 0xF7}},
+			want:  [][]byte{{0xF8}, {0xF0, 0x43, 0x10, 0xF7}},
+		},
+		{
+			name:  "sysex cancels running status",
+			feeds: [][]byte{{0x90, 60, 100, 0xF0, 0x01, 0xF7, 61, 100}},
+			want:  [][]byte{{0x90, 60, 100}, {0xF0, 0x01, 0xF7}},
+		},
+		{
+			name:  "unterminated sysex aborted by a status",
+			feeds: [][]byte{{0xF0, 0x01, 0x02, 0x90, 60, 100}},
+			want:  [][]byte{{0x90, 60, 100}},
+		},
+		{
+			name:  "truncated message dropped",
+			feeds: [][]byte{{0x90, 60, 0xB0, 7, 127}},
+			want:  [][]byte{{0xB0, 7, 127}},
+		},
+		{
+			name:  "stray data bytes",
+			feeds: [][]byte{{60, 100, 0x80, 60, 0}},
+			want:  [][]byte{{0x80, 60, 0}},
+		},
+		{
+			name:  "stray end of sysex",
+			feeds: [][]byte{{0xF7, 0xC0, 1}},
+			want:  [][]byte{{0xC0, 1}},
+		},
+		{
+			name:  "system common cancels running status",
+			feeds: [][]byte{{0x90, 60, 100, 0xF2, 0x10, 0x20, 61, 100, 0xF6}},
+			want:  [][]byte{{0x90, 60, 100}, {0xF2, 0x10, 0x20}, {0xF6}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p midiParser
+			var got [][]byte
+			for _, data := range tt.feeds {
+				p.feed(data, func(msg []byte) { got = append(got, msg) })
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got % X, want % X", got, tt.want)
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], tt.want[i]) {
+					t.Errorf("message %d = % X, want % X", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMidiParserOwnsMessages(t *testing.T) {
+	var p midiParser
+	var got [][]byte
+	p.feed([]byte{0xB0, 1, 2, 3, 4}, func(msg []byte) { got = append(got, msg) })
+	if len(got) != 2 || !bytes.Equal(got[0], []byte{0xB0, 1, 2}) {
+		t.Errorf("got % X, the first message was overwritten", got)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+)
+
+// midiOut holds MIDI messages waiting for the next JACK cycle.
+var midiOut = make(chan []byte, 256)
+
+// sendMidi queues a complete MIDI message on the midi_out port. Messages
+// are dropped when the queue is full, JACK being stopped or stalled.
+func sendMidi(msg []byte) {
+	select {
+	case midiOut <- msg:
+	default:
+		slog.Warn("MIDI output queue full, dropping message", slog.String("msg", fmt.Sprintf("% X", msg)))
+	}
+}
+
+// send queues the MIDI message of an action triggered by ev.
+func (ma *MidiAction) send(ev MidiEvent) {
+	msg, err := ma.message(ev)
+	if err != nil {
+		slog.Error("Failed to build MIDI message", slog.Any("err", err))
+		return
+	}
+	slog.Info("MIDI sent", slog.String("msg", fmt.Sprintf("% X", msg)))
+	sendMidi(msg)
+}
+
+func (ma *MidiAction) message(ev MidiEvent) ([]byte, error) {
+	if ma.SysEx != "" {
+		b, err := parseHex(ma.SysEx)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) < 2 || b[0] != 0xF0 || b[len(b)-1] != 0xF7 {
+			return nil, fmt.Errorf("SysEx must start with F0 and end with F7: %s", ma.SysEx)
+		}
+		return b, nil
+	}
+
+	channel := ev.Channel
+	if ma.Channel != nil {
+		channel = uint8(*ma.Channel)
+	}
+	if channel < 1 || channel > 16 {
+		channel = 1
+	}
+
+	data := midiValue(ev)
+	if ma.Value != nil {
+		val := resolveValue(ma.Value, ev)
+		f, ok := toFloat(val)
+		if !ok {
+			return nil, fmt.Errorf("invalid MIDI value: %v", val)
+		}
+		data = byte(math.Round(min(max(f, 0), 127)))
+	}
+
+	switch {
+	case ma.CC != nil:
+		return []byte{0xB0 | (channel - 1), byte(*ma.CC) & 0x7F, data}, nil
+	case ma.Note != nil:
+		return []byte{0x90 | (channel - 1), byte(*ma.Note) & 0x7F, data}, nil
+	}
+	return nil, fmt.Errorf("MIDI action needs cc, note or sysex")
+}
+
+// midiValue returns the value of an event on the 7-bit MIDI scale.
+func midiValue(ev MidiEvent) byte {
+	v := ev.Value
+	if ev.Max != 0 && ev.Max != 127 {
+		v = v * 127 / ev.Max
+	}
+	return byte(min(max(v, 0), 127))
+}
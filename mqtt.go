@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+const mqttTimeout = 5 * time.Second
+
+// The broker connection is opened on the first mqtt action and kept, the
+// client reconnecting by itself. QoS 1 and 2 messages published while it
+// is down are sent once reconnected, QoS 0 ones are lost.
+var (
+	mqttMu      sync.Mutex
+	mqttClient  mqtt.Client
+	mqttConnect mqtt.Token // first connection, waited for by the first messages
+	mqttConf    MQTTConfig // of mqttClient, reconnected when the config changes
+)
+
+func mqttConnection() (mqtt.Client, mqtt.Token, error) {
+	cfg := activeConfig.Load()
+	if cfg.MQTT == nil || cfg.MQTT.Broker == "" {
+		return nil, nil, fmt.Errorf("mqtt action without mqtt broker in the config")
+	}
+	mqttMu.Lock()
+	defer mqttMu.Unlock()
+	if mqttClient != nil && mqttConf == *cfg.MQTT {
+		return mqttClient, mqttConnect, nil
+	}
+	if mqttClient != nil {
+		mqttClient.Disconnect(250)
+	}
+	mqttConf = *cfg.MQTT
+	broker := mqttConf.Broker
+	opts := mqtt.NewClientOptions().
+		AddBroker(mqttConf.Broker).
+		SetClientID(mqttConf.ClientID).
+		SetUsername(mqttConf.Username).
+		SetPassword(mqttConf.Password).
+		SetConnectRetry(true).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(func(mqtt.Client) {
+			slog.Info("MQTT connected", slog.String("broker", broker))
+		}).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			slog.Warn("MQTT connection lost", slog.String("broker", broker), slog.Any("err", err))
+		})
+	if mqttConf.ClientID == "" {
+		opts.SetClientID(cfg.clientName())
+	}
+	mqttClient = mqtt.NewClient(opts)
+	mqttConnect = mqttClient.Connect()
+	return mqttClient, mqttConnect, nil
+}
+
+// send publishes the message of an action triggered by ev, without waiting
+// for the broker.
+func (ma *MQTTAction) send(ev MidiEvent) {
+	c, connect, err := mqttConnection()
+	if err != nil {
+		slog.Error("Failed to publish MQTT message", ev.mappingAttr(), slog.Any("err", err))
+		return
+	}
+	topic := expandTemplate(ma.Topic, ev)
+	payload := strconv.FormatInt(ev.Value, 10)
+	if ma.Payload != nil {
+		payload = fmt.Sprint(resolveValue(ma.Payload, ev))
+	}
+	go func() {
+		connect.WaitTimeout(mqttTimeout)
+		token := c.Publish(topic, ma.QoS, ma.Retained, payload)
+		if !token.WaitTimeout(mqttTimeout) {
+			slog.Warn("MQTT publish not acknowledged", slog.String("topic", topic))
+		} else if err := token.Error(); err != nil {
+			slog.Error("Failed to publish MQTT message", slog.String("topic", topic), slog.Any("err", err))
+		}
+	}()
+	slog.Info("MQTT sent", ev.mappingAttr(), slog.String("topic", topic), slog.String("payload", payload))
+}
+
+// closeMQTT flushes the pending messages before exiting.
+func closeMQTT() {
+	mqttMu.Lock()
+	defer mqttMu.Unlock()
+	if mqttClient != nil {
+		mqttClient.Disconnect(250)
+	}
+}
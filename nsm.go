@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fjammes/midi2osc/resources"
+	"github.com/hypebeast/go-osc/osc"
+)
+
+// New Session Manager client, enabled when started by NSM with NSM_URL set.
+// The session gives the config path and the JACK client name; the config
+// is edited by hand, so there is nothing to write on save.
+
+const nsmTimeout = 10 * time.Second
+
+type nsmClient struct {
+	conn   *net.UDPConn
+	server *net.UDPAddr
+}
+
+// joinNSM announces midi2osc to the session manager and waits for it to
+// open the client, returning the config path and the client id. A session
+// without config starts with the -config one, or the embedded one.
+func joinNSM(nsmURL string) (string, string, error) {
+	u, err := url.Parse(nsmURL)
+	if err != nil {
+		return "", "", err
+	}
+	server, err := net.ResolveUDPAddr("udp", u.Host)
+	if err != nil {
+		return "", "", err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return "", "", err
+	}
+	c := &nsmClient{conn: conn, server: server}
+	announce := newMessage("/nsm/server/announce")
+	announce.Append("midi2osc", ":", filepath.Base(os.Args[0]), int32(1), int32(2), int32(os.Getpid()))
+	c.send(announce)
+
+	conn.SetReadDeadline(time.Now().Add(nsmTimeout))
+	defer conn.SetReadDeadline(time.Time{})
+	buf := make([]byte, 65536)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			conn.Close()
+			return "", "", fmt.Errorf("no answer from NSM: %w", err)
+		}
+		packet, err := osc.ParsePacket(string(buf[:n]))
+		if err != nil {
+			continue
+		}
+		var path, id string
+		var nsmErr error
+		walkPacket(packet, func(m *osc.Message) {
+			args := stringArgs(m)
+			switch {
+			case m.Address == "/error" && len(args) == 3 && args[0] == "/nsm/server/announce":
+				nsmErr = errors.New(args[2])
+			case m.Address == "/reply" && len(args) >= 2 && args[0] == "/nsm/server/announce":
+				slog.Info("Announced to NSM", slog.String("message", args[1]))
+			case m.Address == "/nsm/client/open" && len(args) == 3:
+				path, id = args[0], args[2]
+			}
+		})
+		if nsmErr != nil {
+			conn.Close()
+			return "", "", nsmErr
+		}
+		if path == "" {
+			continue
+		}
+		config := path + ".yaml"
+		if err := seedConfig(config); err != nil {
+			c.reply("/error", "/nsm/client/open", int32(-1), err.Error())
+			conn.Close()
+			return "", "", err
+		}
+		c.reply("/reply", "/nsm/client/open", "Loaded "+config)
+		slog.Info("Opened by NSM", slog.String("config", config), slog.String("client_id", id))
+		go c.serve()
+		return config, id, nil
+	}
+}
+
+// seedConfig writes the config of a new session.
+func seedConfig(path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	b := []byte(resources.MidiMappingYaml)
+	if configPath != "" {
+		var err error
+		if b, err = readConfig(configPath); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+// serve answers the session manager, saving being acknowledged.
+func (c *nsmClient) serve() {
+	buf := make([]byte, 65536)
+	for {
+		n, err := c.conn.Read(buf)
+		if err != nil {
+			slog.Error("NSM connection closed", slog.Any("err", err))
+			return
+		}
+		packet, err := osc.ParsePacket(string(buf[:n]))
+		if err != nil {
+			continue
+		}
+		walkPacket(packet, func(m *osc.Message) {
+			switch m.Address {
+			case "/nsm/client/save":
+				c.reply("/reply", m.Address, "Config is saved by editing it")
+			case "/nsm/client/open":
+				c.reply("/error", m.Address, int32(-1), "Already open, restart to switch sessions")
+			}
+		})
+	}
+}
+
+func (c *nsmClient) reply(addr string, args ...interface{}) {
+	m := newMessage(addr)
+	m.Append(args...)
+	c.send(m)
+}
+
+func (c *nsmClient) send(m *message) {
+	data, err := m.MarshalBinary()
+	if err == nil {
+		_, err = c.conn.WriteToUDP(data, c.server)
+	}
+	if err != nil {
+		slog.Error("Failed to send to NSM", slog.Any("err", err))
+	}
+}
+
+func stringArgs(m *osc.Message) []string {
+	var args []string
+	for _, a := range m.Arguments {
+		s, _ := a.(string)
+		args = append(args, s)
+	}
+	return args
+}
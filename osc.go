@@ -0,0 +1,506 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hypebeast/go-osc/osc"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+var (
+	udpConnsMu sync.Mutex
+	udpConns   = map[string]*net.UDPConn{}
+)
+
+// sendUDP sends a packet over a UDP socket kept open per target address.
+// Broadcast addresses need nothing special since Go enables SO_BROADCAST on
+// UDP sockets; multicast groups get the TTL and interface of the target.
+func sendUDP(target, addr string, data []byte) error {
+	udpConnsMu.Lock()
+	defer udpConnsMu.Unlock()
+
+	opts := targetOptions(target)
+	conn, ok := udpConns[addr]
+	if !ok {
+		c, err := (&net.Dialer{Timeout: opts.dialTimeout()}).Dial("udp", addr)
+		if err != nil {
+			return err
+		}
+		conn = c.(*net.UDPConn)
+		if raddr := conn.RemoteAddr().(*net.UDPAddr); raddr.IP.IsMulticast() {
+			if err := setMulticastOptions(conn, raddr.IP, opts); err != nil {
+				conn.Close()
+				return err
+			}
+		}
+		udpConns[addr] = conn
+	}
+	conn.SetWriteDeadline(time.Now().Add(opts.writeTimeout()))
+	_, err := conn.Write(data)
+	return err
+}
+
+func setMulticastOptions(conn *net.UDPConn, group net.IP, opts TargetOptions) error {
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = 1
+	}
+	var ifi *net.Interface
+	if opts.Interface != "" {
+		var err error
+		if ifi, err = net.InterfaceByName(opts.Interface); err != nil {
+			return err
+		}
+	}
+
+	if group.To4() != nil {
+		pc := ipv4.NewPacketConn(conn)
+		if ifi != nil {
+			if err := pc.SetMulticastInterface(ifi); err != nil {
+				return err
+			}
+		}
+		return pc.SetMulticastTTL(ttl)
+	}
+	pc := ipv6.NewPacketConn(conn)
+	if ifi != nil {
+		if err := pc.SetMulticastInterface(ifi); err != nil {
+			return err
+		}
+	}
+	return pc.SetMulticastHopLimit(ttl)
+}
+
+var (
+	unixConnsMu sync.Mutex
+	unixConns   = map[string]*net.UnixConn{}
+)
+
+// sendUnix sends a packet to a local OSC server listening on a Unix
+// datagram socket, such as liblo's osc.unix:// servers.
+func sendUnix(target, path string, data []byte) error {
+	unixConnsMu.Lock()
+	defer unixConnsMu.Unlock()
+
+	conn, ok := unixConns[path]
+	if !ok {
+		var err error
+		conn, err = net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+		if err != nil {
+			return err
+		}
+		unixConns[path] = conn
+	}
+	conn.SetWriteDeadline(time.Now().Add(targetOptions(target).writeTimeout()))
+	if _, err := conn.Write(data); err != nil {
+		// The server may have been restarted, dial again next time
+		conn.Close()
+		delete(unixConns, path)
+		return err
+	}
+	return nil
+}
+
+// splitTarget returns the transport and address of an OSC target such as
+// "osc.udp://host:port". Targets without a scheme use UDP. Services
+// discovered with mDNS are written "mdns://<instance name>".
+func splitTarget(target string) (string, string, error) {
+	scheme, addr, found := strings.Cut(target, "://")
+	if !found {
+		scheme, addr = "osc.udp", target
+	}
+	transport, ok := strings.CutPrefix(scheme, "osc.")
+	switch {
+	case !ok:
+		return "", "", fmt.Errorf("unsupported OSC target scheme: %s", scheme)
+	case transport == "unix":
+		return transport, addr, nil
+	case transport != "udp" && transport != "tcp" && transport != "tcps":
+		return "", "", fmt.Errorf("unsupported OSC target scheme: %s", scheme)
+	}
+
+	// host:port, with IPv6 literals in brackets such as [::1]:9000
+	addr = strings.TrimSuffix(addr, "/")
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid OSC target %q: %w", target, err)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil || host == "" {
+		return "", "", fmt.Errorf("invalid OSC target %q: bad host or port", target)
+	}
+	return transport, addr, nil
+}
+
+// targetOptions returns the transport options configured for target.
+func targetOptions(target string) TargetOptions {
+	cfg := activeConfig.Load()
+	if cfg == nil {
+		return TargetOptions{}
+	}
+	return cfg.Targets[target]
+}
+
+// sendEvent sends the actions of an event to their targets, either as
+// separate messages or as one bundle per target.
+func sendEvent(ev MidiEvent) {
+	if ev.Mapping != nil && ev.Mapping.Script != "" && !ev.step {
+		actions, err := scriptActions(ev.Mapping.Script, ev)
+		if err != nil {
+			slog.Error("Lua script failed", ev.mappingAttr(), slog.String("script", ev.Mapping.Script), slog.Any("err", err))
+		}
+		ev.Actions = append(slices.Clip(ev.Actions), actions...)
+	}
+	ev = splitSequence(ev)
+	if ev.bundled || ev.Mapping != nil && ev.Mapping.Bundle {
+		sendBundles(ev)
+		return
+	}
+
+	for _, act := range ev.Actions {
+		if localAction(act, ev) {
+			continue
+		}
+		msg, err := buildMessage(act, ev)
+		if err != nil {
+			slog.Error("Failed to build OSC message", ev.mappingAttr(), slog.String("path", act.Path), slog.Any("err", err))
+			continue
+		}
+		at, err := act.schedule(time.Now())
+		if err != nil {
+			slog.Error("Invalid OSC action schedule", ev.mappingAttr(), slog.String("path", act.Path), slog.Any("err", err))
+			continue
+		}
+		var packet osc.Packet = msg
+		if !at.IsZero() {
+			b := newBundle(at)
+			b.messages = append(b.messages, msg)
+			packet = b
+		}
+		for _, target := range actionTargets(act, ev) {
+			if skipDuplicates(ev) && duplicate(target, msg) {
+				slog.Debug("OSC duplicate skipped", ev.mappingAttr(), slog.String("target", target), slog.String("path", msg.Address))
+				continue
+			}
+			err := sendPacket(target, packet)
+			if err != nil {
+				forgetSent(target, msg)
+				slog.Error("Failed to send OSC", ev.mappingAttr(), slog.String("target", target), slog.String("path", msg.Address), slog.String("cause", countSendError(err)), slog.Any("err", err))
+				retryLater(target, packet)
+			} else {
+				recordOSCEcho(msg)
+				publishOSC(msg)
+				slog.Info("OSC sent", ev.mappingAttr(), slog.String("target", target), slog.String("path", msg.Address), slog.Any("val", msg.Arguments))
+			}
+		}
+	}
+}
+
+// localAction runs an action that does not send OSC and reports whether
+// act was one.
+func localAction(act OSCAction, ev MidiEvent) bool {
+	switch {
+	case act.Midi != nil:
+		act.Midi.send(ev)
+	case act.Led != nil:
+		act.Led.send(float64(midiValue(ev)))
+	case act.MQTT != nil:
+		act.MQTT.send(ev)
+	case act.HTTP != nil:
+		act.HTTP.send(ev)
+	case act.Exec != nil:
+		act.Exec.run(ev)
+	case act.ArtNet != nil:
+		act.ArtNet.send(ev)
+	case act.Set != nil:
+		setVariables(act.Set, ev)
+	case act.Profile != "":
+		setProfile(act.Profile)
+	default:
+		return false
+	}
+	return true
+}
+
+func actionTargets(act OSCAction, ev MidiEvent) TargetList {
+	if len(act.OscTarget) > 0 {
+		return act.OscTarget
+	}
+	return ev.Target
+}
+
+// bundleKey groups the actions sent in one bundle: same target and same
+// execution time.
+type bundleKey struct {
+	target string
+	at     time.Time
+}
+
+func sendBundles(ev MidiEvent) {
+	now := time.Now()
+	var delay time.Duration
+	if ev.Mapping != nil {
+		delay = time.Duration(ev.Mapping.BundleDelayMs) * time.Millisecond
+	}
+	mappingAt := afterDelay(delay)
+
+	var keys []bundleKey
+	bundles := map[bundleKey]*bundle{}
+	for _, act := range ev.Actions {
+		if localAction(act, ev) {
+			continue
+		}
+		msg, err := buildMessage(act, ev)
+		if err != nil {
+			slog.Error("Failed to build OSC message", ev.mappingAttr(), slog.String("path", act.Path), slog.Any("err", err))
+			continue
+		}
+		at, err := act.schedule(now)
+		if err != nil {
+			slog.Error("Invalid OSC action schedule", ev.mappingAttr(), slog.String("path", act.Path), slog.Any("err", err))
+			continue
+		}
+		if at.IsZero() {
+			at = mappingAt
+		}
+		for _, target := range actionTargets(act, ev) {
+			if skipDuplicates(ev) && duplicate(target, msg) {
+				continue
+			}
+			key := bundleKey{target, at}
+			b, ok := bundles[key]
+			if !ok {
+				b = newBundle(at)
+				bundles[key] = b
+				keys = append(keys, key)
+			}
+			b.messages = append(b.messages, msg)
+		}
+	}
+
+	for _, key := range keys {
+		target, b := key.target, bundles[key]
+		if err := sendPacket(target, b); err != nil {
+			for _, msg := range b.messages {
+				forgetSent(target, msg)
+			}
+			slog.Error("Failed to send OSC bundle", ev.mappingAttr(), slog.String("target", target), slog.String("cause", countSendError(err)), slog.Any("err", err))
+			retryLater(target, b)
+		} else {
+			for _, msg := range b.messages {
+				recordOSCEcho(msg)
+				publishOSC(msg)
+			}
+			slog.Info("OSC bundle sent", ev.mappingAttr(), slog.String("target", target), slog.Int("messages", len(b.messages)))
+		}
+	}
+}
+
+func sendPacket(target string, packet osc.Packet) error {
+	if name, ok := strings.CutPrefix(target, "mdns://"); ok {
+		resolved, err := resolveService(name)
+		if err != nil {
+			return err
+		}
+		if err := sendPacket(resolved, packet); err != nil {
+			forgetService(name)
+			return err
+		}
+		return nil
+	}
+
+	transport, addr, err := splitTarget(target)
+	if err != nil {
+		return err
+	}
+
+	data, err := packet.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	switch transport {
+	case "unix":
+		return sendUnix(target, addr, data)
+	case "tcp", "tcps":
+		conn, err := tcpConnFor(target, addr, transport == "tcps")
+		if err != nil {
+			return err
+		}
+		return conn.send(data)
+	}
+	return sendUDP(target, addr, data)
+}
+
+// schedule returns when the action is to be executed, the zero time meaning
+// immediately.
+func (act OSCAction) schedule(now time.Time) (time.Time, error) {
+	if act.At == "" {
+		return afterDelay(time.Duration(act.TimetagDelayMs) * time.Millisecond), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, act.At); err == nil {
+		return t.Add(time.Duration(act.TimetagDelayMs) * time.Millisecond), nil
+	}
+	clock, err := time.ParseInLocation(time.TimeOnly, act.At, now.Location())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected RFC 3339 or HH:MM:SS", act.At)
+	}
+	t := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), clock.Second(), clock.Nanosecond(), now.Location())
+	if !t.After(now) {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t.Add(time.Duration(act.TimetagDelayMs) * time.Millisecond), nil
+}
+
+// buildMessage creates the OSC message of an action, with either its single
+// typed value or its list of arguments, after MIDI placeholder substitution.
+// Numeric arguments may be computed with an expression, see evalExpression.
+func buildMessage(act OSCAction, ev MidiEvent) (*message, error) {
+	msg := newMessage(expandTemplate(act.Path, ev))
+	// Without a type nor a value, e.g. {path="/stop"} from a script, the
+	// message has no arguments
+	if len(act.Args) == 0 && (act.Type != "" || act.Value != nil) {
+		act.Args = []OSCArg{{Type: act.Type, Value: act.Value, Transform: act.Transform}}
+	}
+	for i, arg := range act.Args {
+		val := resolveValue(arg.Value, ev)
+		if strings.Contains("ifhd", arg.Type) && len(arg.Type) == 1 && isExpression(val) {
+			var err error
+			if val, err = evalExpression(val.(string), ev); err != nil {
+				return nil, fmt.Errorf("argument %d: %w", i, err)
+			}
+		}
+		if arg.Transform != nil {
+			val = arg.Transform.apply(val)
+		}
+		if err := appendArg(msg, arg.Type, val); err != nil {
+			return nil, fmt.Errorf("argument %d: %w", i, err)
+		}
+	}
+	return msg, nil
+}
+
+func appendArg(msg *message, t string, val interface{}) error {
+	switch t {
+	case "i":
+		i, ok := toInt(val)
+		if !ok {
+			return fmt.Errorf("invalid value for OSC type i: %v", val)
+		}
+		msg.Append(int32(i))
+	case "f":
+		f, ok := toFloat(val)
+		if !ok {
+			return fmt.Errorf("invalid value for OSC type f: %v", val)
+		}
+		msg.Append(float32(f))
+	case "s":
+		msg.Append(fmt.Sprint(val))
+	case "b":
+		b, err := blobValue(val)
+		if err != nil {
+			return fmt.Errorf("invalid value for OSC type b: %w", err)
+		}
+		msg.Append(b)
+	case "h":
+		i, ok := toInt(val)
+		if !ok {
+			return fmt.Errorf("invalid value for OSC type h: %v", val)
+		}
+		msg.Append(int64(i))
+	case "d":
+		f, ok := toFloat(val)
+		if !ok {
+			return fmt.Errorf("invalid value for OSC type d: %v", val)
+		}
+		msg.Append(f)
+	case "c":
+		if s, ok := val.(string); ok && s != "" {
+			msg.Append(oscChar([]rune(s)[0]))
+		} else if i, ok := toInt(val); ok {
+			msg.Append(oscChar(i))
+		} else {
+			return fmt.Errorf("invalid value for OSC type c: %v", val)
+		}
+	case "m":
+		m, ok := parseMIDI(val)
+		if !ok {
+			return fmt.Errorf("invalid value for OSC type m: %v", val)
+		}
+		msg.Append(m)
+	case "r":
+		c, ok := parseRGBA(val)
+		if !ok {
+			return fmt.Errorf("invalid value for OSC type r: %v", val)
+		}
+		msg.Append(c)
+	case "T":
+		msg.Append(true)
+	case "F":
+		msg.Append(false)
+	case "N":
+		msg.Append(nil)
+	case "I":
+		msg.Append(oscInfinitum{})
+	default:
+		return fmt.Errorf("unsupported OSC type: %s", t)
+	}
+	return nil
+}
+
+// blobValue returns the payload of a blob argument, given as raw bytes, an
+// inline hex string, {hex: "..."} or {file: path}.
+func blobValue(val interface{}) ([]byte, error) {
+	switch v := val.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return parseHex(v)
+	case map[string]interface{}:
+		if path, ok := v["file"].(string); ok {
+			return os.ReadFile(path)
+		}
+		if h, ok := v["hex"].(string); ok {
+			return parseHex(h)
+		}
+	}
+	return nil, fmt.Errorf("expected hex string, {hex: ...} or {file: ...}, got %v", val)
+}
+
+func toInt(val interface{}) (int, bool) {
+	switch v := val.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		return int(v), true
+	case string:
+		i, err := strconv.Atoi(v)
+		return i, err == nil
+	}
+	return 0, false
+}
+
+func toFloat(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
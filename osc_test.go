@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestSplitTarget(t *testing.T) {
+	tests := []struct {
+		target    string
+		transport string
+		addr      string
+		wantErr   bool
+	}{
+		{target: "localhost:9000", transport: "udp", addr: "localhost:9000"},
+		{target: "osc.udp://mixer.local:10023", transport: "udp", addr: "mixer.local:10023"},
+		{target: "osc.tcp://host:9000/", transport: "tcp", addr: "host:9000"},
+		{target: "osc.tcps://host:9000", transport: "tcps", addr: "host:9000"},
+		{target: "192.168.1.20:8000", transport: "udp", addr: "192.168.1.20:8000"},
+		{target: "osc.udp://127.0.0.1:57120", transport: "udp", addr: "127.0.0.1:57120"},
+		{target: "[::1]:9000", transport: "udp", addr: "[::1]:9000"},
+		{target: "osc.tcp://[fe80::1%eth0]:9000", transport: "tcp", addr: "[fe80::1%eth0]:9000"},
+		{target: "osc.unix:///tmp/osc.sock", transport: "unix", addr: "/tmp/osc.sock"},
+
+		{target: "::1", wantErr: true},              // IPv6 without brackets nor port
+		{target: "[::1]", wantErr: true},            // no port
+		{target: "::1:9000", wantErr: true},         // IPv6 port without brackets
+		{target: "localhost", wantErr: true},        // no port
+		{target: ":9000", wantErr: true},            // no host
+		{target: "host:", wantErr: true},            // empty port
+		{target: "host:70000", wantErr: true},       // port out of range
+		{target: "host:osc", wantErr: true},         // named port
+		{target: "http://host:9000", wantErr: true}, // not an OSC scheme
+		{target: "osc.sctp://host:9000", wantErr: true},
+	}
+	for _, tt := range tests {
+		transport, addr, err := splitTarget(tt.target)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("splitTarget(%q) = %q, %q, want an error", tt.target, transport, addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitTarget(%q): %v", tt.target, err)
+			continue
+		}
+		if transport != tt.transport || addr != tt.addr {
+			t.Errorf("splitTarget(%q) = %q, %q, want %q, %q", tt.target, transport, addr, tt.transport, tt.addr)
+		}
+	}
+}
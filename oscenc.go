@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/hypebeast/go-osc/osc"
+)
+
+// OSC argument types go-osc has no Go type for.
+type (
+	oscChar      rune
+	oscMIDI      [4]byte // port id, status, data1, data2
+	oscRGBA      [4]byte
+	oscInfinitum struct{}
+)
+
+// message is an OSC message that also encodes oscChar, oscMIDI, oscRGBA
+// and oscInfinitum arguments.
+type message struct {
+	osc.Message
+}
+
+func newMessage(addr string) *message {
+	return &message{osc.Message{Address: addr}}
+}
+
+func (m *message) MarshalBinary() ([]byte, error) {
+	tags := []byte{','}
+	var payload []byte
+	for _, arg := range m.Arguments {
+		switch v := arg.(type) {
+		case bool:
+			if v {
+				tags = append(tags, 'T')
+			} else {
+				tags = append(tags, 'F')
+			}
+		case nil:
+			tags = append(tags, 'N')
+		case oscInfinitum:
+			tags = append(tags, 'I')
+		case int32:
+			tags = append(tags, 'i')
+			payload = binary.BigEndian.AppendUint32(payload, uint32(v))
+		case float32:
+			tags = append(tags, 'f')
+			payload = binary.BigEndian.AppendUint32(payload, math.Float32bits(v))
+		case int64:
+			tags = append(tags, 'h')
+			payload = binary.BigEndian.AppendUint64(payload, uint64(v))
+		case float64:
+			tags = append(tags, 'd')
+			payload = binary.BigEndian.AppendUint64(payload, math.Float64bits(v))
+		case oscChar:
+			tags = append(tags, 'c')
+			payload = binary.BigEndian.AppendUint32(payload, uint32(v))
+		case oscMIDI:
+			tags = append(tags, 'm')
+			payload = append(payload, v[:]...)
+		case oscRGBA:
+			tags = append(tags, 'r')
+			payload = append(payload, v[:]...)
+		case string:
+			tags = append(tags, 's')
+			payload = appendPaddedString(payload, v)
+		case []byte:
+			tags = append(tags, 'b')
+			payload = binary.BigEndian.AppendUint32(payload, uint32(len(v)))
+			payload = append(payload, v...)
+			payload = append(payload, make([]byte, pad4(len(v)))...)
+		default:
+			return nil, fmt.Errorf("unsupported OSC argument type: %T", arg)
+		}
+	}
+
+	data := appendPaddedString(nil, m.Address)
+	data = appendPaddedString(data, string(tags))
+	return append(data, payload...), nil
+}
+
+// bundle is an OSC bundle of messages.
+type bundle struct {
+	timetag  osc.Timetag
+	messages []*message
+}
+
+// newBundle returns a bundle to be executed at the given time, or
+// immediately when at is the zero time.
+func newBundle(at time.Time) *bundle {
+	return &bundle{timetag: *osc.NewTimetag(at)}
+}
+
+// afterDelay returns the time delay from now, or the zero time meaning
+// "immediately" when delay is not positive.
+func afterDelay(delay time.Duration) time.Time {
+	if delay <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(delay)
+}
+
+func (b *bundle) MarshalBinary() ([]byte, error) {
+	data := appendPaddedString(nil, "#bundle")
+	data = binary.BigEndian.AppendUint64(data, b.timetag.TimeTag())
+	for _, m := range b.messages {
+		elem, err := m.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		data = binary.BigEndian.AppendUint32(data, uint32(len(elem)))
+		data = append(data, elem...)
+	}
+	return data, nil
+}
+
+// appendPaddedString appends a NUL terminated string padded to 4 bytes.
+func appendPaddedString(data []byte, s string) []byte {
+	data = append(data, s...)
+	return append(data, make([]byte, 4-len(s)%4)...)
+}
+
+func pad4(n int) int {
+	return (4 - n%4) % 4
+}
+
+// parseRGBA reads a color written as "#RRGGBB", "#RRGGBBAA" or a list of
+// 3 or 4 components.
+func parseRGBA(val interface{}) (oscRGBA, bool) {
+	var c oscRGBA
+	c[3] = 0xFF
+	switch v := val.(type) {
+	case string:
+		b, err := hex.DecodeString(strings.TrimPrefix(v, "#"))
+		if err != nil || len(b) < 3 || len(b) > 4 {
+			return c, false
+		}
+		copy(c[:], b)
+		return c, true
+	case []interface{}:
+		return c, bytesFromList(v, c[:]) && (len(v) == 3 || len(v) == 4)
+	}
+	return c, false
+}
+
+// parseMIDI reads a MIDI argument written as a list of up to 4 bytes
+// (port id, status, data1, data2) or a hex string such as "00 90 3C 7F".
+func parseMIDI(val interface{}) (oscMIDI, bool) {
+	var m oscMIDI
+	switch v := val.(type) {
+	case string:
+		b, err := parseHex(v)
+		if err != nil || len(b) > 4 {
+			return m, false
+		}
+		copy(m[4-len(b):], b)
+		return m, true
+	case []interface{}:
+		if len(v) > 4 {
+			return m, false
+		}
+		return m, bytesFromList(v, m[4-len(v):])
+	}
+	return m, false
+}
+
+func bytesFromList(list []interface{}, dst []byte) bool {
+	if len(list) > len(dst) {
+		return false
+	}
+	for i, item := range list {
+		n, ok := toInt(item)
+		if !ok || n < 0 || n > 255 {
+			return false
+		}
+		dst[i] = byte(n)
+	}
+	return true
+}
+
+// parseHex decodes space separated or contiguous hex bytes.
+func parseHex(s string) ([]byte, error) {
+	return hex.DecodeString(strings.Join(strings.Fields(s), ""))
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+	"golang.org/x/net/websocket"
+)
+
+// OSCQuery access flags of a node.
+const (
+	oscqRead  = 1 // midi2osc sends it, its value can be queried and listened to
+	oscqWrite = 2 // midi2osc receives it as feedback
+)
+
+type oscqNode struct {
+	FullPath string               `json:"FULL_PATH"`
+	Contents map[string]*oscqNode `json:"CONTENTS,omitempty"`
+	Type     string               `json:"TYPE,omitempty"`
+	Access   int                  `json:"ACCESS"`
+	Value    []interface{}        `json:"VALUE,omitempty"`
+
+	Description string `json:"DESCRIPTION,omitempty"`
+}
+
+// oscqListener is a WebSocket client of LISTEN, its packets being written
+// by a goroutine of its own so a slow client never delays the OSC output.
+type oscqListener struct {
+	paths map[string]bool
+	out   chan []byte // dropped when full
+}
+
+var (
+	oscqMu        sync.Mutex
+	oscqValues    = map[string][]interface{}{} // last value by OSC address
+	oscqListeners = map[*websocket.Conn]*oscqListener{}
+)
+
+// startOSCQuery serves the OSC namespace over HTTP and WebSocket following
+// the OSCQuery proposal, and announces it with mDNS.
+func startOSCQuery(conf *OSCQueryConfig) error {
+	ln, err := net.Listen("tcp", conf.Listen)
+	if err != nil {
+		return err
+	}
+	name := conf.Name
+	if name == "" {
+		name = activeConfig.Load().clientName()
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	if _, err := zeroconf.Register(name, "_oscjson._tcp", "local.", port, nil, nil); err != nil {
+		slog.Warn("Failed to announce OSCQuery server", slog.Any("err", err))
+	}
+	slog.Info("OSCQuery server listening", slog.String("addr", ln.Addr().String()))
+
+	ws := websocket.Server{Handler: serveOSCQueryListen}
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			ws.ServeHTTP(w, r)
+			return
+		}
+		serveOSCQuery(w, r, name)
+	}))
+	return nil
+}
+
+func serveOSCQuery(w http.ResponseWriter, r *http.Request, name string) {
+	var reply interface{}
+	switch r.URL.RawQuery {
+	case "HOST_INFO":
+		reply = hostInfo(name)
+	default:
+		node := oscNamespace().find(r.URL.Path)
+		if node == nil {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.URL.RawQuery {
+		case "":
+			reply = node
+		case "VALUE":
+			reply = map[string]interface{}{"VALUE": node.Value}
+		case "TYPE":
+			reply = map[string]interface{}{"TYPE": node.Type}
+		case "ACCESS":
+			reply = map[string]interface{}{"ACCESS": node.Access}
+		case "FULL_PATH":
+			reply = map[string]interface{}{"FULL_PATH": node.FullPath}
+		default:
+			// Unsupported attributes must be answered with 204
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reply)
+}
+
+func hostInfo(name string) map[string]interface{} {
+	info := map[string]interface{}{
+		"NAME": name,
+		"EXTENSIONS": map[string]bool{
+			"ACCESS": true, "VALUE": true, "TYPE": true, "LISTEN": true, "DESCRIPTION": true,
+		},
+	}
+	if fb := activeConfig.Load().Feedback; fb != nil {
+		if _, port, err := net.SplitHostPort(fb.Listen); err == nil {
+			if p, err := strconv.Atoi(port); err == nil {
+				info["OSC_PORT"] = p
+				info["OSC_TRANSPORT"] = "UDP"
+			}
+		}
+	}
+	return info
+}
+
+// oscNamespace builds the tree of the OSC addresses midi2osc sends or
+// receives. Addresses built from templates or wildcards are left out.
+func oscNamespace() *oscqNode {
+	cfg := activeConfig.Load()
+	root := &oscqNode{FullPath: "/"}
+	for _, m := range cfg.Mappings {
+		desc := m.Description
+		if desc == "" {
+			desc = m.Name
+		}
+		for _, actions := range [][]OSCAction{m.Actions, m.OffActions, m.OnTap, m.OnDoubleTap, m.OnHold} {
+			root.addActions(actions, desc)
+		}
+	}
+	root.addActions(cfg.OnStart, "")
+	root.addActions(cfg.OnExit, "")
+	for _, t := range cfg.Timers {
+		root.addActions(t.Actions, "")
+	}
+	if cfg.Banks != nil {
+		root.add(defaultPath(cfg.Banks.Path, "/bank"), "i", oscqRead)
+	}
+	if len(cfg.Profiles) > 0 {
+		root.add("/profile", "s", oscqRead)
+	}
+	if cfg.RawMidi != nil {
+		root.add(defaultPath(cfg.RawMidi.Path, "/midi/raw"), "b", oscqRead)
+	}
+	if cfg.Clock != nil {
+		root.add(defaultPath(cfg.Clock.Path, "/tempo"), "f", oscqRead)
+		for _, p := range []string{cfg.Clock.StartPath, cfg.Clock.StopPath, cfg.Clock.ContinuePath} {
+			if p != "" {
+				root.add(p, "T", oscqRead)
+			}
+		}
+	}
+	if cfg.MTC != nil {
+		root.add(defaultPath(cfg.MTC.Path, "/timecode"), "s", oscqRead)
+	}
+	if cfg.Feedback != nil {
+		for _, fm := range cfg.Feedback.Mappings {
+			root.add(fm.Path, "f", oscqWrite)
+		}
+		for _, c := range controlCommands {
+			access := oscqWrite
+			if c.reply {
+				access = oscqRead
+			}
+			if n := root.add(controlPrefix+c.name, c.typ, access); n != nil {
+				n.Description = c.desc
+			}
+		}
+	}
+	return root
+}
+
+// addActions adds the paths of the OSC actions, midi, mqtt... actions
+// having none.
+func (n *oscqNode) addActions(actions []OSCAction, desc string) {
+	for _, act := range actions {
+		if act.Path == "" {
+			continue
+		}
+		typ := act.Type
+		if len(act.Args) > 0 {
+			typ = ""
+			for _, arg := range act.Args {
+				typ += arg.Type
+			}
+		}
+		if node := n.add(act.Path, typ, oscqRead); node != nil && node.Description == "" {
+			node.Description = desc
+		}
+	}
+}
+
+func defaultPath(path, def string) string {
+	if path == "" {
+		return def
+	}
+	return path
+}
+
+func (n *oscqNode) add(path, typ string, access int) *oscqNode {
+	if !strings.HasPrefix(path, "/") || strings.ContainsAny(path, "*?[]{}") {
+		return nil
+	}
+	for _, part := range strings.Split(strings.Trim(path, "/"), "/") {
+		if n.Contents == nil {
+			n.Contents = map[string]*oscqNode{}
+		}
+		child, ok := n.Contents[part]
+		if !ok {
+			child = &oscqNode{FullPath: strings.TrimSuffix(n.FullPath, "/") + "/" + part}
+			n.Contents[part] = child
+		}
+		n = child
+	}
+	if n.Type == "" {
+		n.Type = typ
+	}
+	n.Access |= access
+	oscqMu.Lock()
+	n.Value = oscqValues[path]
+	oscqMu.Unlock()
+	return n
+}
+
+func (n *oscqNode) find(path string) *oscqNode {
+	for _, part := range strings.Split(strings.Trim(path, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		if n = n.Contents[part]; n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// serveOSCQueryListen handles the LISTEN and IGNORE commands of a WebSocket
+// client, which then receives the values of the listened addresses as OSC
+// packets in binary frames.
+func serveOSCQueryListen(ws *websocket.Conn) {
+	l := &oscqListener{paths: map[string]bool{}, out: make(chan []byte, 64)}
+	oscqMu.Lock()
+	oscqListeners[ws] = l
+	oscqMu.Unlock()
+	defer func() {
+		oscqMu.Lock()
+		delete(oscqListeners, ws)
+		close(l.out)
+		oscqMu.Unlock()
+	}()
+	go func() {
+		failed := false
+		for data := range l.out {
+			if failed {
+				continue
+			}
+			ws.SetWriteDeadline(time.Now().Add(time.Second))
+			if err := websocket.Message.Send(ws, data); err != nil {
+				failed = true
+				ws.Close() // ends the receive loop
+			}
+		}
+	}()
+
+	for {
+		var cmd struct {
+			Command string `json:"COMMAND"`
+			Data    string `json:"DATA"`
+		}
+		if err := websocket.JSON.Receive(ws, &cmd); err != nil {
+			return
+		}
+		oscqMu.Lock()
+		switch cmd.Command {
+		case "LISTEN":
+			l.paths[cmd.Data] = true
+		case "IGNORE":
+			delete(l.paths, cmd.Data)
+		}
+		oscqMu.Unlock()
+	}
+}
+
+// publishOSC records the value of a message sent or received, and streams
+// it to the OSCQuery clients listening to its address.
+func publishOSC(msg *message) {
+	if cfg := activeConfig.Load(); cfg == nil || cfg.OSCQuery == nil {
+		return
+	}
+	oscqMu.Lock()
+	defer oscqMu.Unlock()
+	oscqValues[msg.Address] = msg.Arguments
+	var data []byte
+	for _, l := range oscqListeners {
+		if !l.paths[msg.Address] {
+			continue
+		}
+		if data == nil {
+			var err error
+			if data, err = msg.MarshalBinary(); err != nil {
+				return
+			}
+		}
+		select {
+		case l.out <- data:
+		default:
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// overrides are the -set flags, "path=value" with a path such as
+// mappings[0].actions[0].value. They apply to every loaded config.
+var overrides overrideFlags
+
+type overrideFlags []string
+
+func (o *overrideFlags) String() string { return strings.Join(*o, " ") }
+
+func (o *overrideFlags) Set(s string) error {
+	if !strings.Contains(s, "=") {
+		return fmt.Errorf("%q is not path=value", s)
+	}
+	*o = append(*o, s)
+	return nil
+}
+
+// applyOverrides sets the config values of the -set flags, the values
+// being decoded as YAML.
+func (c *Config) applyOverrides() error {
+	for _, o := range overrides {
+		path, value, _ := strings.Cut(o, "=")
+		keys, err := splitOverride(path)
+		if err != nil {
+			return err
+		}
+		if err := setValue(reflect.ValueOf(c).Elem(), keys, value); err != nil {
+			return fmt.Errorf("-set %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// splitOverride splits a path into field names, indexes and map keys:
+// a.b[0][key] gives a, b, 0 and key.
+func splitOverride(path string) ([]string, error) {
+	var keys []string
+	for rest := path; rest != ""; {
+		if key, ok := strings.CutPrefix(rest, "["); ok {
+			// Brackets allow dots in map keys, such as target addresses
+			key, after, ok := strings.Cut(key, "]")
+			if !ok {
+				return nil, fmt.Errorf("-set %s: missing ]", path)
+			}
+			keys = append(keys, key)
+			rest = strings.TrimPrefix(after, ".")
+			continue
+		}
+		i := strings.IndexAny(rest, ".[")
+		if i < 0 {
+			i = len(rest)
+		}
+		keys = append(keys, rest[:i])
+		rest = strings.TrimPrefix(rest[i:], ".")
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("-set: empty path")
+	}
+	return keys, nil
+}
+
+// setValue decodes value into the element of v at keys.
+func setValue(v reflect.Value, keys []string, value string) error {
+	if len(keys) == 0 {
+		return yaml.Unmarshal([]byte(value), v.Addr().Interface())
+	}
+	key := keys[0]
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return setValue(v.Elem(), keys, value)
+	case reflect.Struct:
+		var names []string
+		for i := range v.NumField() {
+			name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+			if name == key && v.Field(i).CanSet() {
+				return setValue(v.Field(i), keys[1:], value)
+			}
+			names = append(names, name)
+		}
+		if s := closest(key, names); s != "" {
+			return fmt.Errorf("unknown field %q (did you mean %q?)", key, s)
+		}
+		return fmt.Errorf("unknown field %q", key)
+	case reflect.Slice:
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i >= v.Len() {
+			return fmt.Errorf("index %s out of 0-%d", key, v.Len()-1)
+		}
+		return setValue(v.Index(i), keys[1:], value)
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		k := reflect.ValueOf(key).Convert(v.Type().Key())
+		// Map elements are not addressable, set a copy
+		elem := reflect.New(v.Type().Elem()).Elem()
+		if e := v.MapIndex(k); e.IsValid() {
+			elem.Set(e)
+		}
+		if err := setValue(elem, keys[1:], value); err != nil {
+			return err
+		}
+		v.SetMapIndex(k, elem)
+		return nil
+	}
+	return fmt.Errorf("%q has no field %q", v.Type(), key)
+}
@@ -0,0 +1,108 @@
+//go:build linux && !nojack
+
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/xthexder/go-jack"
+)
+
+// PipeWire is used through its JACK API, libjack being replaced by
+// pipewire-jack. When the system libjack is the one of JACK, midi2osc
+// runs itself again with pw-jack.
+
+// pwJackEnv marks the process started by pw-jack, not to loop.
+const pwJackEnv = "MIDI2OSC_PW_JACK"
+
+func init() {
+	inputs["pipewire"] = openPipeWire
+}
+
+// pipeWireInput is a JACK client connected to the MIDI nodes of PipeWire.
+type pipeWireInput struct {
+	*jackInput
+}
+
+func openPipeWire(name string) (midiInput, error) {
+	if !pipeWireRunning() {
+		return nil, errors.New("PipeWire is not running")
+	}
+	j, err := openJack(name)
+	if err != nil {
+		pwJack, lookErr := exec.LookPath("pw-jack")
+		if lookErr != nil || os.Getenv(pwJackEnv) != "" {
+			return nil, err
+		}
+		self, exeErr := os.Executable()
+		if exeErr != nil {
+			return nil, err
+		}
+		slog.Info("Running again with pw-jack", slog.Any("err", err))
+		os.Setenv(pwJackEnv, "1")
+		return nil, syscall.Exec(pwJack, append([]string{pwJack, self}, os.Args[1:]...), os.Environ())
+	}
+	return &pipeWireInput{j}, nil
+}
+
+// pipeWireRunning reports whether the socket of the PipeWire daemon exists.
+func pipeWireRunning() bool {
+	dir := os.Getenv("PIPEWIRE_RUNTIME_DIR")
+	if dir == "" {
+		dir = os.Getenv("XDG_RUNTIME_DIR")
+	}
+	remote := os.Getenv("PIPEWIRE_REMOTE")
+	if remote == "" {
+		remote = "pipewire-0"
+	}
+	if !filepath.IsAbs(remote) {
+		remote = filepath.Join(dir, remote)
+	}
+	_, err := os.Stat(remote)
+	return err == nil
+}
+
+func (p *pipeWireInput) start() error {
+	if err := p.jackInput.start(); err != nil {
+		return err
+	}
+	p.connectNodes()
+	return nil
+}
+
+// connectNodes connects the MIDI ports of the devices (the physical ports,
+// "Midi-Bridge" in PipeWire) to midi_in and midi_out, those containing
+// -input-port when set.
+func (p *pipeWireInput) connectNodes() {
+	c := p.client
+	connect := func(src, dst string) {
+		if code := c.Connect(src, dst); code != 0 {
+			slog.Warn("Failed to connect MIDI node", slog.String("src", src), slog.String("dst", dst), slog.Any("err", jack.StrError(code)))
+			return
+		}
+		slog.Info("Connected MIDI node", slog.String("src", src), slog.String("dst", dst))
+	}
+	discovered := func(flags uint64) []string {
+		var ports []string
+		for _, port := range c.GetPorts("", jack.DEFAULT_MIDI_TYPE, flags|jack.PortIsPhysical) {
+			// Midi Through echoes what is sent to it
+			if strings.Contains(port, "Midi Through") || !strings.Contains(port, inputPort) {
+				continue
+			}
+			ports = append(ports, port)
+		}
+		return ports
+	}
+	for _, src := range discovered(jack.PortIsOutput) {
+		connect(src, portsIn[0].GetName())
+	}
+	for _, dst := range discovered(jack.PortIsInput) {
+		connect(portOut.GetName(), dst)
+	}
+}
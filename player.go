@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+)
+
+// smfEvent is a MIDI message of a standard MIDI file, at its time from
+// the start.
+type smfEvent struct {
+	at  time.Duration
+	msg []byte
+}
+
+// filePlayer feeds the mappings from a MIDI file instead of a MIDI input,
+// to test them without hardware. The program exits at the end of the file.
+type filePlayer struct {
+	path     string
+	events   []smfEvent
+	noTiming bool
+	stop     chan struct{}
+}
+
+func openPlayer(path string, noTiming bool) (*filePlayer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	events, err := parseSMF(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &filePlayer{path: path, events: events, noTiming: noTiming, stop: make(chan struct{})}, nil
+}
+
+func (p *filePlayer) start() error {
+	go discardMidiOut()
+	go p.play()
+	return nil
+}
+
+func (p *filePlayer) close() {
+	close(p.stop)
+}
+
+func (p *filePlayer) play() {
+	slog.Info("Playing MIDI file", slog.String("file", p.path), slog.Int("events", len(p.events)))
+	start := time.Now()
+	for _, ev := range p.events {
+		if p.noTiming {
+			waitEvents(cap(eventChan) / 2)
+			select {
+			case <-p.stop:
+				return
+			default:
+			}
+		} else if d := time.Until(start.Add(ev.at)); d > 0 {
+			select {
+			case <-time.After(d):
+			case <-p.stop:
+				return
+			}
+		}
+		cfgMu.RLock()
+		if activeConfig.Load() != nil {
+			handleMessage(ev.msg)
+		}
+		cfgMu.RUnlock()
+	}
+	flushEvents()
+	slog.Info("End of MIDI file", slog.Duration("duration", time.Since(start)))
+	// Not close(ch), which the remote inputs may still send to
+	close(inputEnded)
+}
+
+// parseSMF returns the MIDI messages of a standard MIDI file, format 0 or
+// 1, merged in time order. Meta events other than tempo changes are
+// skipped.
+func parseSMF(b []byte) ([]smfEvent, error) {
+	if len(b) < 14 || string(b[:4]) != "MThd" {
+		return nil, errors.New("not a standard MIDI file")
+	}
+	hlen := int(binary.BigEndian.Uint32(b[4:]))
+	if hlen < 6 || 8+hlen > len(b) {
+		return nil, errors.New("truncated header")
+	}
+	format := binary.BigEndian.Uint16(b[8:])
+	division := binary.BigEndian.Uint16(b[12:])
+	if format > 1 {
+		return nil, fmt.Errorf("unsupported format %d", format)
+	}
+	b = b[8+hlen:]
+
+	type tickEvent struct {
+		tick  uint64
+		msg   []byte
+		tempo uint32 // microseconds per quarter note, 0 for messages
+	}
+	var all []tickEvent
+	for track := 0; len(b) >= 8; track++ {
+		size := int(binary.BigEndian.Uint32(b[4:]))
+		if 8+size > len(b) {
+			return nil, fmt.Errorf("track %d truncated", track)
+		}
+		kind, chunk := string(b[:4]), b[8:8+size]
+		b = b[8+size:]
+		if kind != "MTrk" {
+			continue // unknown chunks are skipped
+		}
+		var tick uint64
+		var running byte
+		for r := bytes.NewReader(chunk); r.Len() > 0; {
+			delta, err := readVLQ(r)
+			if err != nil {
+				return nil, fmt.Errorf("track %d: %w", track, err)
+			}
+			tick += uint64(delta)
+			status, err := r.ReadByte()
+			if err != nil {
+				return nil, fmt.Errorf("track %d: %w", track, err)
+			}
+			switch {
+			case status == 0xFF:
+				typ, err := r.ReadByte()
+				if err != nil {
+					return nil, fmt.Errorf("track %d: %w", track, err)
+				}
+				data, err := readSMFData(r)
+				if err != nil {
+					return nil, fmt.Errorf("track %d: %w", track, err)
+				}
+				if typ == 0x51 && len(data) == 3 {
+					all = append(all, tickEvent{tick: tick, tempo: uint32(data[0])<<16 | uint32(data[1])<<8 | uint32(data[2])})
+				}
+			case status == 0xF0 || status == 0xF7:
+				data, err := readSMFData(r)
+				if err != nil {
+					return nil, fmt.Errorf("track %d: %w", track, err)
+				}
+				// F7 escapes any bytes, F0 starts a SysEx whose F0 is implied
+				if status == 0xF0 {
+					data = append([]byte{0xF0}, data...)
+				}
+				all = append(all, tickEvent{tick: tick, msg: data})
+				running = 0
+			default:
+				msg := []byte{status}
+				if status < 0x80 {
+					if running == 0 {
+						return nil, fmt.Errorf("track %d: data byte without status", track)
+					}
+					msg = []byte{running, status}
+				} else {
+					running = status
+				}
+				for len(msg) < messageLen(msg[0]) {
+					c, err := r.ReadByte()
+					if err != nil {
+						return nil, fmt.Errorf("track %d: %w", track, err)
+					}
+					msg = append(msg, c)
+				}
+				all = append(all, tickEvent{tick: tick, msg: msg})
+			}
+		}
+	}
+	// Stable, the tempo changes of track 0 coming first at the same tick
+	slices.SortStableFunc(all, func(a, b tickEvent) int { return cmp.Compare(a.tick, b.tick) })
+
+	tickDur := func(tempo uint32) time.Duration {
+		if division&0x8000 != 0 { // SMPTE: frames per second and ticks per frame
+			fps := int(-int8(division >> 8))
+			return time.Second / time.Duration(fps*int(division&0xFF))
+		}
+		return time.Duration(tempo) * time.Microsecond / time.Duration(max(1, division))
+	}
+	var events []smfEvent
+	var at time.Duration
+	var last uint64
+	tempo := uint32(500000) // 120 BPM
+	for _, e := range all {
+		at += time.Duration(e.tick-last) * tickDur(tempo)
+		last = e.tick
+		if e.tempo != 0 {
+			tempo = e.tempo
+			continue
+		}
+		events = append(events, smfEvent{at: at, msg: e.msg})
+	}
+	return events, nil
+}
+
+func readVLQ(r *bytes.Reader) (uint32, error) {
+	var v uint32
+	for range 4 {
+		c, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		v = v<<7 | uint32(c&0x7F)
+		if c&0x80 == 0 {
+			return v, nil
+		}
+	}
+	return 0, errors.New("invalid variable-length quantity")
+}
+
+func readSMFData(r *bytes.Reader) ([]byte, error) {
+	n, err := readVLQ(r)
+	if err != nil {
+		return nil, err
+	}
+	if int(n) > r.Len() {
+		return nil, errors.New("truncated event")
+	}
+	data := make([]byte, n)
+	r.Read(data)
+	return data, nil
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestReadVLQ(t *testing.T) {
+	tests := []struct {
+		in      []byte
+		want    uint32
+		wantErr bool
+	}{
+		{in: []byte{0x00}, want: 0},
+		{in: []byte{0x40}, want: 0x40},
+		{in: []byte{0x7F}, want: 0x7F},
+		{in: []byte{0x81, 0x00}, want: 0x80},
+		{in: []byte{0xC0, 0x00}, want: 0x2000},
+		{in: []byte{0xFF, 0x7F}, want: 0x3FFF},
+		{in: []byte{0x81, 0x80, 0x00}, want: 0x4000},
+		{in: []byte{0xFF, 0xFF, 0xFF, 0x7F}, want: 0x0FFFFFFF},
+		{in: []byte{0xFF, 0xFF, 0xFF, 0xFF, 0x7F}, wantErr: true}, // more than 4 bytes
+		{in: []byte{0x81}, wantErr: true},                         // truncated
+		{in: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := readVLQ(bytes.NewReader(tt.in))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("readVLQ(% X) = %d, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("readVLQ(% X) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+// smf builds a standard MIDI file with a division of 96 ticks per quarter
+// note.
+func smf(format uint16, tracks ...[]byte) []byte {
+	b := []byte("MThd\x00\x00\x00\x06")
+	b = append(b, byte(format>>8), byte(format), 0, byte(len(tracks)), 0, 96)
+	for _, tr := range tracks {
+		b = append(b, "MTrk"...)
+		b = append(b, byte(len(tr)>>24), byte(len(tr)>>16), byte(len(tr)>>8), byte(len(tr)))
+		b = append(b, tr...)
+	}
+	return b
+}
+
+func TestParseSMF(t *testing.T) {
+	const tick = 500 * time.Millisecond / 96 // at 120 BPM
+	endOfTrack := []byte{0x00, 0xFF, 0x2F, 0x00}
+
+	tests := []struct {
+		name    string
+		file    []byte
+		want    []smfEvent
+		wantErr bool
+	}{
+		{
+			name: "running status",
+			file: smf(0, append([]byte{
+				0x00, 0x90, 60, 100,
+				0x60, 60, 0, // running status, one quarter note later
+			}, endOfTrack...)),
+			want: []smfEvent{
+				{at: 0, msg: []byte{0x90, 60, 100}},
+				{at: 96 * tick, msg: []byte{0x90, 60, 0}},
+			},
+		},
+		{
+			name: "tempo change",
+			file: smf(0, append([]byte{
+				0x00, 0xFF, 0x51, 0x03, 0x0E, 0xA6, 0x00, // 960ms per quarter note
+				0x60, 0xB0, 7, 100,
+			}, endOfTrack...)),
+			want: []smfEvent{
+				{at: 960 * time.Millisecond, msg: []byte{0xB0, 7, 100}},
+			},
+		},
+		{
+			name: "sysex",
+			file: smf(0, append([]byte{
+				0x00, 0xF0, 0x05, 0x7E, 0x7F, 0x06, 0x01, 0xF7,
+			}, endOfTrack...)),
+			want: []smfEvent{
+				{at: 0, msg: []byte{0xF0, 0x7E, 0x7F, 0x06, 0x01, 0xF7}},
+			},
+		},
+		{
+			name: "format 1 tracks merged",
+			file: smf(1,
+				append([]byte{0x00, 0xFF, 0x51, 0x03, 0x0E, 0xA6, 0x00}, endOfTrack...),
+				append([]byte{0x60, 0x91, 62, 90}, endOfTrack...),
+				append([]byte{0x30, 0xC2, 5}, endOfTrack...),
+			),
+			want: []smfEvent{
+				{at: 480 * time.Millisecond, msg: []byte{0xC2, 5}},
+				{at: 960 * time.Millisecond, msg: []byte{0x91, 62, 90}},
+			},
+		},
+		{
+			name:    "not a midi file",
+			file:    []byte("RIFF\x00\x00\x00\x06\x00\x00\x00\x01\x00\x60"),
+			wantErr: true,
+		},
+		{
+			name:    "format 2",
+			file:    smf(2, endOfTrack),
+			wantErr: true,
+		},
+		{
+			name:    "data byte without status",
+			file:    smf(0, []byte{0x00, 60, 100}),
+			wantErr: true,
+		},
+		{
+			name:    "truncated message",
+			file:    smf(0, []byte{0x00, 0x90, 60}),
+			wantErr: true,
+		},
+		{
+			name:    "truncated track",
+			file:    smf(0, endOfTrack)[:24],
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSMF(tt.file)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i].at != tt.want[i].at || !bytes.Equal(got[i].msg, tt.want[i].msg) {
+					t.Errorf("event %d = %v % X, want %v % X", i, got[i].at, got[i].msg, tt.want[i].at, tt.want[i].msg)
+				}
+			}
+		})
+	}
+}
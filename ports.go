@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// inPort is the input port, 1-based, of the MIDI message being handled.
+// Only accessed from the JACK thread.
+var inPort = 1
+
+// The messages of the WebMIDI pages come from their own port, selected
+// with device: webmidi.
+const (
+	webMIDIDevice = "webmidi"
+	webMIDIPort   = -1
+)
+
+// onPort reports whether a mapping listens to the port of the message.
+func (m *Mapping) onPort() bool {
+	return m.Port == 0 || m.Port == inPort
+}
+
+// resolveDevices sets the port of the mappings naming a device of
+// midi_inputs.
+func (c *Config) resolveDevices() error {
+	for i := range c.Mappings {
+		m := &c.Mappings[i]
+		if m.Port < 0 || m.Port > max(1, len(c.MidiInputs)) {
+			return fmt.Errorf("mapping %d: port %d out of 1-%d", i+1, m.Port, max(1, len(c.MidiInputs)))
+		}
+		if m.Device == "" {
+			continue
+		}
+		if m.Device == webMIDIDevice {
+			if m.Port != 0 {
+				return fmt.Errorf("mapping %d: device %s has no port number", i+1, webMIDIDevice)
+			}
+			m.Port = webMIDIPort
+			continue
+		}
+		n := slices.Index(c.MidiInputs, m.Device)
+		if n < 0 {
+			if s := closest(m.Device, c.MidiInputs); s != "" {
+				return fmt.Errorf("mapping %d: unknown device %q (did you mean %q?)", i+1, m.Device, s)
+			}
+			return fmt.Errorf("mapping %d: unknown device %q, not in midi_inputs", i+1, m.Device)
+		}
+		if m.Port != 0 && m.Port != n+1 {
+			return fmt.Errorf("mapping %d: device %q is port %d, not %d", i+1, m.Device, n+1, m.Port)
+		}
+		m.Port = n + 1
+	}
+	return nil
+}
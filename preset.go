@@ -0,0 +1,49 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/fjammes/midi2osc/resources"
+)
+
+// loadPreset loads a built-in config by name.
+func loadPreset(name string) (*Config, error) {
+	b, err := resources.Presets.ReadFile("presets/" + name + ".yaml")
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("unknown preset %q, see midi2osc list-presets", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseConfig(b)
+}
+
+// runListPresets implements "midi2osc list-presets", printing the name and
+// the first comment line of each preset.
+func runListPresets() int {
+	entries, err := resources.Presets.ReadDir("presets")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	status := 0
+	for _, e := range entries {
+		b, err := resources.Presets.ReadFile("presets/" + e.Name())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		line, _, _ := bufio.NewReader(bytes.NewReader(b)).ReadLine()
+		desc, _ := strings.CutPrefix(string(line), "# ")
+		fmt.Printf("%-24s %s\n", strings.TrimSuffix(e.Name(), path.Ext(e.Name())), desc)
+	}
+	return status
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+)
+
+// selectedProfile is the profile chosen with -profile or switched to,
+// empty for the default profile of the config.
+var selectedProfile atomic.Value
+
+func init() {
+	selectedProfile.Store("")
+}
+
+// activeProfile returns the name of the active profile, empty when none.
+func activeProfile() string {
+	if p := selectedProfile.Load().(string); p != "" {
+		return p
+	}
+	return activeConfig.Load().Profile
+}
+
+// inProfile reports whether a mapping is active in the selected profile.
+func (m *Mapping) inProfile() bool {
+	return m.profile == "" || m.profile == activeProfile()
+}
+
+// addProfiles appends the mappings of the profiles to the mappings shared
+// by all profiles, in profile name order.
+func (c *Config) addProfiles() error {
+	if c.Profile != "" {
+		if _, ok := c.Profiles[c.Profile]; !ok {
+			return fmt.Errorf("unknown default profile %q", c.Profile)
+		}
+	}
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		for _, m := range c.Profiles[name] {
+			m.profile = name
+			c.Mappings = append(c.Mappings, m)
+		}
+	}
+	return nil
+}
+
+// setProfile switches to a profile and notifies the OSC targets of the
+// change.
+func setProfile(name string) {
+	cfg := activeConfig.Load()
+	if _, ok := cfg.Profiles[name]; !ok {
+		slog.Warn("Unknown profile", slog.String("profile", name))
+		return
+	}
+	if selectedProfile.Swap(name) == name {
+		return
+	}
+	slog.Info("Switched profile", slog.String("profile", name))
+	dispatch(MidiEvent{
+		Target:  cfg.OscTarget,
+		Actions: []OSCAction{{Path: "/profile", Type: "s", Value: name}},
+	})
+}
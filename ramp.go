@@ -0,0 +1,99 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultRampInterval = 10 * time.Millisecond
+
+type rampState struct {
+	current int64 // last value sent, -1 before the first one
+	target  int64
+	ev      MidiEvent // latest event, sent with the intermediate values
+	active  bool
+}
+
+var (
+	rampsMu sync.Mutex
+	ramps   = map[controlKey]*rampState{} // by control, see throttle
+)
+
+func resetRamps() {
+	rampsMu.Lock()
+	ramps = map[controlKey]*rampState{}
+	rampsMu.Unlock()
+}
+
+// ramp reports whether an event may be sent as is. When the value of a
+// mapping with ramp jumps by more than the threshold, the event is replaced
+// by intermediate values sent at the ramp interval, avoiding zipper noise.
+// Events arriving during a ramp move its target.
+func ramp(ev MidiEvent) bool {
+	m := ev.Mapping
+	if m == nil || m.Ramp == nil || ev.ramped {
+		return true
+	}
+	full := ev.Max
+	if full == 0 {
+		full = 127
+	}
+	step := max(1, int64(m.Ramp.Threshold)*full/127)
+
+	rampsMu.Lock()
+	defer rampsMu.Unlock()
+	k := ev.controlKey()
+	st, ok := ramps[k]
+	if !ok {
+		st = &rampState{current: -1}
+		ramps[k] = st
+	}
+	st.target, st.ev = ev.Value, ev
+	if st.active {
+		return false
+	}
+	if st.current < 0 || abs(ev.Value-st.current) <= step {
+		st.current = ev.Value
+		return true
+	}
+	st.active = true
+	go runRamp(st, step, m.Ramp.interval())
+	return false
+}
+
+func runRamp(st *rampState, step int64, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		rampsMu.Lock()
+		if st.target > st.current {
+			st.current = min(st.current+step, st.target)
+		} else {
+			st.current = max(st.current-step, st.target)
+		}
+		ev := st.ev
+		ev.Value, ev.ramped = st.current, true
+		done := st.current == st.target
+		st.active = !done
+		rampsMu.Unlock()
+
+		eventChan <- ev
+		if done {
+			return
+		}
+	}
+}
+
+func (r *Ramp) interval() time.Duration {
+	if r.IntervalMs <= 0 {
+		return defaultRampInterval
+	}
+	return time.Duration(r.IntervalMs) * time.Millisecond
+}
+
+func abs(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
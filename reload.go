@@ -0,0 +1,105 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay groups the file events of a single save, editors often
+// writing a file in several steps.
+const reloadDelay = 200 * time.Millisecond
+
+var (
+	watcher    *fsnotify.Watcher
+	watchMu    sync.Mutex
+	watchFiles map[string]bool // config files and directories, by clean path
+)
+
+// watchConfig reloads the config on SIGHUP and when one of its files
+// changes.
+func watchConfig(files []string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reload()
+		}
+	}()
+
+	var err error
+	watcher, err = fsnotify.NewWatcher()
+	if err != nil {
+		slog.Warn("Config file not watched", slog.Any("err", err))
+		return
+	}
+	watch(files)
+	go func() {
+		var timer *time.Timer
+		for {
+			select {
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if !isConfigFile(ev.Name) || !ev.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename|fsnotify.Remove) {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(reloadDelay, reload)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("Config watcher error", slog.Any("err", err))
+			}
+		}
+	}()
+}
+
+// watch replaces the watched files by the files of the current config.
+func watch(files []string) {
+	if watcher == nil {
+		return
+	}
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	watchFiles = map[string]bool{}
+	for _, f := range files {
+		watchFiles[filepath.Clean(f)] = true
+		dir := f
+		if info, err := os.Stat(f); err != nil || !info.IsDir() {
+			// The directory is watched since editors may replace the file
+			dir = filepath.Dir(f)
+		}
+		if err := watcher.Add(dir); err != nil {
+			slog.Warn("Config file not watched", slog.String("file", f), slog.Any("err", err))
+		}
+	}
+}
+
+// isConfigFile tells if name is a config file, or a YAML file in a config
+// directory.
+func isConfigFile(name string) bool {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return false
+	}
+	watchMu.Lock()
+	defer watchMu.Unlock()
+	return watchFiles[abs] || (isConfigExt(abs) && watchFiles[filepath.Dir(abs)])
+}
+
+func reload() {
+	if err := reloadConfig(); err != nil {
+		slog.Error("Failed to reload config, keeping the current one", slog.String("file", configPath), slog.Any("err", err))
+	}
+}
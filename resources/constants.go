@@ -1,8 +1,23 @@
 package resources
 
 import (
-	_ "embed"
+	"embed"
 )
 
 //go:embed midimapping.yaml
 var MidiMappingYaml string
+
+// Controllers holds the built-in controller definitions, controllers/<name>.yaml
+//
+//go:embed controllers/*.yaml
+var Controllers embed.FS
+
+// Presets holds the built-in configs, presets/<name>.yaml
+//
+//go:embed presets/*.yaml
+var Presets embed.FS
+
+// WebMIDIPage forwards the MIDI inputs of a browser to the WebMIDI server.
+//
+//go:embed webmidi.html
+var WebMIDIPage string
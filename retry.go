@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/hypebeast/go-osc/osc"
+)
+
+const (
+	retryMinBackoff = 100 * time.Millisecond
+	retryMaxBackoff = 5 * time.Second
+)
+
+var (
+	retryQueuesMu sync.Mutex
+	retryQueues   = map[string]chan osc.Packet{}
+)
+
+// retryLater queues a packet whose send failed, when the target has retries
+// enabled. It reports whether the packet was queued.
+func retryLater(target string, packet osc.Packet) bool {
+	opts := targetOptions(target)
+	if opts.Retries <= 0 {
+		return false
+	}
+
+	retryQueuesMu.Lock()
+	queue, ok := retryQueues[target]
+	if !ok {
+		size := opts.RetryQueue
+		if size <= 0 {
+			size = 64
+		}
+		queue = make(chan osc.Packet, size)
+		retryQueues[target] = queue
+		go runRetries(target, opts.Retries, queue)
+	}
+	retryQueuesMu.Unlock()
+
+	select {
+	case queue <- packet:
+		return true
+	default:
+		slog.Warn("OSC retry queue full, dropping packet", slog.String("target", target))
+		return false
+	}
+}
+
+// runRetries resends queued packets in order, backing off exponentially
+// between attempts, and drops a packet after the given number of retries.
+func runRetries(target string, retries int, queue chan osc.Packet) {
+	for packet := range queue {
+		backoff := retryMinBackoff
+		for attempt := 1; ; attempt++ {
+			time.Sleep(backoff)
+			err := sendPacket(target, packet)
+			if err == nil {
+				slog.Info("OSC retry succeeded", slog.String("target", target), slog.Int("attempt", attempt))
+				break
+			}
+			if attempt == retries {
+				slog.Error("OSC retries exhausted, dropping packet", slog.String("target", target), slog.String("cause", countSendError(err)), slog.Any("err", err))
+				break
+			}
+			backoff = min(2*backoff, retryMaxBackoff)
+		}
+	}
+}
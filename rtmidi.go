@@ -0,0 +1,118 @@
+//go:build rtmidi
+
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"gitlab.com/gomidi/midi/v2/drivers"
+	"gitlab.com/gomidi/midi/v2/drivers/rtmididrv"
+)
+
+// The rtmidi backend uses CoreMIDI on macOS, MME on Windows and ALSA on
+// Linux. It needs cgo, build with -tags rtmidi (and nojack without JACK).
+
+func init() {
+	inputs["rtmidi"] = openRtMidi
+}
+
+type rtMidiInput struct {
+	drv  *rtmididrv.Driver
+	in   drivers.In
+	out  drivers.Out
+	stop func()
+}
+
+// openRtMidi opens the ports matching -input-port, or virtual ports named
+// after the client when not set. Windows has no virtual ports.
+func openRtMidi(name string) (midiInput, error) {
+	drv, err := rtmididrv.New()
+	if err != nil {
+		return nil, err
+	}
+	r := &rtMidiInput{drv: drv}
+	if inputPort == "" {
+		r.in, err = drv.OpenVirtualIn(name + " midi_in")
+		if err == nil {
+			r.out, err = drv.OpenVirtualOut(name + " midi_out")
+		}
+	} else {
+		r.in, r.out, err = rtMidiPorts(drv)
+		if err == nil {
+			err = r.in.Open()
+		}
+		if err == nil {
+			err = r.out.Open()
+		}
+	}
+	if err != nil {
+		drv.Close()
+		return nil, err
+	}
+	slog.Info("Opened MIDI ports", slog.String("in", r.in.String()), slog.String("out", r.out.String()))
+	return r, nil
+}
+
+// rtMidiPorts returns the first input and output whose name contains
+// inputPort.
+func rtMidiPorts(drv *rtmididrv.Driver) (drivers.In, drivers.Out, error) {
+	ins, err := drv.Ins()
+	if err != nil {
+		return nil, nil, err
+	}
+	outs, err := drv.Outs()
+	if err != nil {
+		return nil, nil, err
+	}
+	var in drivers.In
+	var out drivers.Out
+	var names []string
+	for _, p := range ins {
+		names = append(names, p.String())
+		if in == nil && strings.Contains(p.String(), inputPort) {
+			in = p
+		}
+	}
+	for _, p := range outs {
+		if out == nil && strings.Contains(p.String(), inputPort) {
+			out = p
+		}
+	}
+	if in == nil || out == nil {
+		return nil, nil, fmt.Errorf("no MIDI input and output matching %q, inputs: %s", inputPort, strings.Join(names, ", "))
+	}
+	return in, out, nil
+}
+
+func (r *rtMidiInput) start() error {
+	var err error
+	r.stop, err = r.in.Listen(func(msg []byte, _ int32) {
+		cfgMu.RLock()
+		defer cfgMu.RUnlock()
+		if activeConfig.Load() != nil {
+			handleMessage(msg)
+		}
+	}, drivers.ListenConfig{SysEx: true, TimeCode: true, OnErr: func(err error) {
+		slog.Error("MIDI input failed", slog.Any("err", err))
+	}})
+	if err != nil {
+		return err
+	}
+	go func() {
+		for msg := range midiOut {
+			if err := r.out.Send(msg); err != nil {
+				slog.Error("MIDI output failed", slog.Any("err", err))
+			}
+		}
+	}()
+	return nil
+}
+
+func (r *rtMidiInput) close() {
+	if r.stop != nil {
+		r.stop()
+	}
+	r.drv.Close()
+}
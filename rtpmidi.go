@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/grandcat/zeroconf"
+)
+
+// RTP-MIDI (AppleMIDI, RFC 6295) sessions, listening on a control port and
+// the data port after it, announced with mDNS like the Network MIDI of
+// macOS and iOS. The recovery journal is ignored.
+
+var (
+	rtpMidiPort    = 5004
+	rtpMidiConnect string // session initiator, host:port of the remote control port
+)
+
+const (
+	rtpMidiVersion     = 2
+	rtpMidiPayloadType = 0x61
+	rtpMidiSyncPeriod  = 10 * time.Second
+)
+
+func init() {
+	inputs["rtpmidi"] = func(name string) (midiInput, error) { return openRTPMidi(name) }
+}
+
+type rtpMidiPeer struct {
+	name   string
+	data   *net.UDPAddr
+	parser midiParser
+}
+
+type rtpMidi struct {
+	name       string
+	ssrc       uint32
+	epoch      time.Time
+	ctrl, data *net.UDPConn
+	mdns       *zeroconf.Server
+
+	mu    sync.Mutex
+	peers map[uint32]*rtpMidiPeer
+	seq   uint16
+	token uint32 // invitation sent, 0 when none
+}
+
+func openRTPMidi(name string) (*rtpMidi, error) {
+	r := &rtpMidi{name: name, ssrc: rand.Uint32(), epoch: time.Now(), peers: map[uint32]*rtpMidiPeer{}}
+	var err error
+	if r.ctrl, err = net.ListenUDP("udp", &net.UDPAddr{Port: rtpMidiPort}); err != nil {
+		return nil, err
+	}
+	if r.data, err = net.ListenUDP("udp", &net.UDPAddr{Port: rtpMidiPort + 1}); err != nil {
+		r.ctrl.Close()
+		return nil, err
+	}
+	if r.mdns, err = zeroconf.Register(name, "_apple-midi._udp", "local.", rtpMidiPort, nil, nil); err != nil {
+		slog.Warn("Failed to announce RTP-MIDI session", slog.Any("err", err))
+	}
+	slog.Info("RTP-MIDI session listening", slog.String("name", name), slog.Int("port", rtpMidiPort))
+	return r, nil
+}
+
+func (r *rtpMidi) start() error {
+	go r.read(r.ctrl)
+	go r.read(r.data)
+	go r.writeLoop()
+	if rtpMidiConnect != "" {
+		addr, err := net.ResolveUDPAddr("udp", rtpMidiConnect)
+		if err != nil {
+			return err
+		}
+		go r.invite(addr)
+	}
+	return nil
+}
+
+func (r *rtpMidi) close() {
+	r.mu.Lock()
+	for ssrc, p := range r.peers {
+		r.data.WriteToUDP(r.command("BY", nil), p.data)
+		delete(r.peers, ssrc)
+	}
+	r.mu.Unlock()
+	if r.mdns != nil {
+		r.mdns.Shutdown()
+	}
+	r.ctrl.Close()
+	r.data.Close()
+}
+
+// now is the session time, in 100 µs units.
+func (r *rtpMidi) now() uint64 {
+	return uint64(time.Since(r.epoch) / (100 * time.Microsecond))
+}
+
+// command builds an AppleMIDI session packet.
+func (r *rtpMidi) command(cmd string, payload []byte) []byte {
+	b := append([]byte{0xFF, 0xFF}, cmd...)
+	return append(b, payload...)
+}
+
+// invitation is the payload of IN and OK: version, token, ssrc and name.
+func (r *rtpMidi) invitation(token uint32) []byte {
+	b := binary.BigEndian.AppendUint32(nil, rtpMidiVersion)
+	b = binary.BigEndian.AppendUint32(b, token)
+	b = binary.BigEndian.AppendUint32(b, r.ssrc)
+	return append(append(b, r.name...), 0)
+}
+
+// invite starts a session with a listener as its initiator, inviting its
+// control port then its data port until it accepts.
+func (r *rtpMidi) invite(ctrl *net.UDPAddr) {
+	r.mu.Lock()
+	r.token = rand.Uint32() | 1
+	token := r.token
+	r.mu.Unlock()
+	for {
+		r.mu.Lock()
+		accepted := r.token != token
+		r.mu.Unlock()
+		if accepted {
+			break
+		}
+		r.ctrl.WriteToUDP(r.command("IN", r.invitation(token)), ctrl)
+		time.Sleep(2 * time.Second)
+	}
+	// The initiator keeps the session alive with clock synchronizations
+	for {
+		time.Sleep(rtpMidiSyncPeriod)
+		r.mu.Lock()
+		var peers []*net.UDPAddr
+		for _, p := range r.peers {
+			peers = append(peers, p.data)
+		}
+		r.mu.Unlock()
+		for _, addr := range peers {
+			r.data.WriteToUDP(r.sync(0, [3]uint64{r.now()}), addr)
+		}
+	}
+}
+
+func (r *rtpMidi) sync(count byte, ts [3]uint64) []byte {
+	b := binary.BigEndian.AppendUint32(nil, r.ssrc)
+	b = append(b, count, 0, 0, 0)
+	for _, t := range ts {
+		b = binary.BigEndian.AppendUint64(b, t)
+	}
+	return r.command("CK", b)
+}
+
+func (r *rtpMidi) read(conn *net.UDPConn) {
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				slog.Error("RTP-MIDI read failed", slog.Any("err", err))
+			}
+			return
+		}
+		b := buf[:n]
+		if len(b) >= 4 && b[0] == 0xFF && b[1] == 0xFF {
+			r.session(conn, addr, string(b[2:4]), b[4:])
+		} else if conn == r.data {
+			r.receive(b)
+		}
+	}
+}
+
+// session answers the AppleMIDI session commands.
+func (r *rtpMidi) session(conn *net.UDPConn, addr *net.UDPAddr, cmd string, b []byte) {
+	switch cmd {
+	case "IN", "OK", "NO":
+		if len(b) < 12 {
+			return
+		}
+		token, ssrc := binary.BigEndian.Uint32(b[4:]), binary.BigEndian.Uint32(b[8:])
+		name, _, _ := bytes.Cut(b[12:], []byte{0})
+		switch cmd {
+		case "IN":
+			conn.WriteToUDP(r.command("OK", r.invitation(token)), addr)
+			if conn == r.data {
+				r.addPeer(ssrc, string(name), addr)
+			}
+		case "OK":
+			r.mu.Lock()
+			invited := token == r.token
+			r.mu.Unlock()
+			if !invited {
+				return
+			}
+			if conn == r.ctrl {
+				data := *addr
+				data.Port++
+				r.data.WriteToUDP(r.command("IN", r.invitation(token)), &data)
+				return
+			}
+			r.addPeer(ssrc, string(name), addr)
+			r.mu.Lock()
+			r.token = 0
+			r.mu.Unlock()
+			r.data.WriteToUDP(r.sync(0, [3]uint64{r.now()}), addr)
+		case "NO":
+			slog.Warn("RTP-MIDI invitation rejected", slog.String("peer", addr.String()))
+		}
+	case "BY":
+		if len(b) < 12 {
+			return
+		}
+		ssrc := binary.BigEndian.Uint32(b[8:])
+		r.mu.Lock()
+		if p, ok := r.peers[ssrc]; ok {
+			slog.Info("RTP-MIDI peer left", slog.String("name", p.name))
+			delete(r.peers, ssrc)
+		}
+		r.mu.Unlock()
+	case "CK":
+		if len(b) < 32 {
+			return
+		}
+		var ts [3]uint64
+		for i := range ts {
+			ts[i] = binary.BigEndian.Uint64(b[8+8*i:])
+		}
+		switch count := b[4]; count {
+		case 0, 1:
+			ts[count+1] = r.now()
+			conn.WriteToUDP(r.sync(count+1, ts), addr)
+		}
+	}
+}
+
+func (r *rtpMidi) addPeer(ssrc uint32, name string, data *net.UDPAddr) {
+	r.mu.Lock()
+	r.peers[ssrc] = &rtpMidiPeer{name: name, data: data}
+	r.mu.Unlock()
+	slog.Info("RTP-MIDI peer connected", slog.String("name", name), slog.String("addr", data.String()))
+}
+
+// receive maps the MIDI commands of an RTP packet.
+func (r *rtpMidi) receive(b []byte) {
+	if len(b) < 13 || b[0]>>6 != 2 || b[1]&0x7F != rtpMidiPayloadType {
+		return
+	}
+	ssrc := binary.BigEndian.Uint32(b[8:])
+	b = b[12:]
+	flags, size := b[0], int(b[0]&0x0F)
+	b = b[1:]
+	if flags&0x80 != 0 { // long header
+		if len(b) < 1 {
+			return
+		}
+		size = size<<8 | int(b[0])
+		b = b[1:]
+	}
+	if size > len(b) {
+		return
+	}
+	stream := rtpMidiStream(b[:size], flags&0x20 != 0)
+
+	cfgMu.RLock()
+	defer cfgMu.RUnlock()
+	r.mu.Lock()
+	p, ok := r.peers[ssrc]
+	r.mu.Unlock()
+	if !ok || activeConfig.Load() == nil {
+		return
+	}
+	p.parser.feed(stream, handleMessage)
+}
+
+// rtpMidiStream converts the MIDI list of a packet to a MIDI byte stream,
+// dropping the delta times. z tells that the first command has one.
+func rtpMidiStream(list []byte, z bool) []byte {
+	var out []byte
+	var running byte
+	for i, first := 0, true; i < len(list); first = false {
+		if !first || z {
+			for n := 0; n < 4 && i < len(list); n++ {
+				i++
+				if list[i-1]&0x80 == 0 {
+					break
+				}
+			}
+		}
+		if i >= len(list) {
+			break
+		}
+		switch b := list[i]; {
+		case b == 0xF0 || b == 0xF7:
+			// SysEx segments: F0..F0 first, F7..F0 middle, F7..F7 last,
+			// F7..F4 cancelled, the next status byte aborting what the
+			// parser has so far
+			j := i + 1
+			for j < len(list) && list[j] != 0xF0 && list[j] != 0xF7 && list[j] != 0xF4 {
+				j++
+			}
+			if j == len(list) {
+				return out
+			}
+			if b == 0xF0 {
+				out = append(out, 0xF0)
+			}
+			if list[j] != 0xF4 {
+				out = append(out, list[i+1:j]...)
+			}
+			if list[j] == 0xF7 {
+				out = append(out, 0xF7)
+			}
+			i = j + 1
+		case b >= 0x80:
+			n := min(messageLen(b), len(list)-i)
+			out = append(out, list[i:i+n]...)
+			if b < 0xF0 {
+				running = b
+			}
+			i += n
+		case running != 0:
+			n := min(messageLen(running)-1, len(list)-i)
+			out = append(out, list[i:i+n]...)
+			i += n
+		default:
+			return out
+		}
+	}
+	return out
+}
+
+// writeLoop sends the queued MIDI messages to the peers, one per packet.
+func (r *rtpMidi) writeLoop() {
+	for msg := range midiOut {
+		if len(msg) == 0 {
+			continue
+		}
+		r.mu.Lock()
+		r.seq++
+		b := []byte{0x80, rtpMidiPayloadType}
+		b = binary.BigEndian.AppendUint16(b, r.seq)
+		b = binary.BigEndian.AppendUint32(b, uint32(r.now()))
+		b = binary.BigEndian.AppendUint32(b, r.ssrc)
+		if len(msg) > 15 {
+			b = append(b, 0x80|byte(len(msg)>>8), byte(len(msg)))
+		} else {
+			b = append(b, byte(len(msg)))
+		}
+		b = append(b, msg...)
+		for _, p := range r.peers {
+			if _, err := r.data.WriteToUDP(b, p.data); err != nil {
+				slog.Error("RTP-MIDI write failed", slog.String("peer", p.name), slog.Any("err", err))
+			}
+		}
+		r.mu.Unlock()
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Schemas of the types with a custom YAML syntax.
+var customSchemas = map[reflect.Type]map[string]interface{}{
+	reflect.TypeOf(ValueRange{}):   {"type": []string{"integer", "string"}},
+	reflect.TypeOf(MidiNumber(0)):  {"type": []string{"integer", "string"}},
+	reflect.TypeOf(ParamNumber(0)): {"type": []string{"integer", "string"}},
+	reflect.TypeOf(SysExPattern{}): {"type": "string"},
+	reflect.TypeOf(yaml.Node{}):    {"type": "object"}, // a mapping with templated values
+	reflect.TypeOf(TargetList{}): {"oneOf": []interface{}{
+		map[string]interface{}{"type": "string"},
+		map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+	}},
+}
+
+// runSchema implements "midi2osc schema": it prints a JSON Schema of the
+// config for editors such as yaml-language-server.
+func runSchema() int {
+	defs := map[string]interface{}{}
+	schema := jsonSchema(reflect.TypeOf(Config{}), defs)
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "midi2osc config"
+	schema["$defs"] = defs
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(schema); err != nil {
+		return 1
+	}
+	return 0
+}
+
+// jsonSchema returns the schema of a config type, adding the structs it
+// uses to defs.
+func jsonSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	if s, ok := customSchemas[t]; ok {
+		return s
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return jsonSchema(t.Elem(), defs)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "minimum": 0, "maximum": 1<<(8*t.Size()) - 1}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem(), defs)}
+	case reflect.Array:
+		return map[string]interface{}{"type": "array", "items": jsonSchema(t.Elem(), defs), "minItems": t.Len(), "maxItems": t.Len()}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": jsonSchema(t.Elem(), defs)}
+	case reflect.Struct:
+		return structSchema(t, defs)
+	}
+	return map[string]interface{}{} // interface{}: any value
+}
+
+// structSchema returns the schema of a struct, by reference to its
+// definition except for the root config.
+func structSchema(t reflect.Type, defs map[string]interface{}) map[string]interface{} {
+	ref := map[string]interface{}{"$ref": "#/$defs/" + t.Name()}
+	if _, ok := defs[t.Name()]; ok {
+		return ref
+	}
+	defs[t.Name()] = nil // placeholder for recursive types
+
+	props := map[string]interface{}{}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" || !f.IsExported() {
+			continue
+		}
+		props[name] = jsonSchema(f.Type, defs)
+	}
+	s := map[string]interface{}{
+		"type":                 "object",
+		"properties":           props,
+		"additionalProperties": false,
+	}
+	if t == reflect.TypeOf(Config{}) {
+		delete(defs, t.Name())
+		return s
+	}
+	defs[t.Name()] = s
+	return ref
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// luaScript is a loaded Lua script. Its global state persists between
+// events so scripts can keep their own state.
+type luaScript struct {
+	mu sync.Mutex
+	L  *lua.LState
+}
+
+var (
+	scriptsMu sync.Mutex
+	scripts   = map[string]*luaScript{}
+)
+
+// loadScript returns the script at path, running it on first use.
+func loadScript(path string) (*luaScript, error) {
+	scriptsMu.Lock()
+	defer scriptsMu.Unlock()
+	if s, ok := scripts[path]; ok {
+		return s, nil
+	}
+	L := lua.NewState()
+	if err := L.DoFile(path); err != nil {
+		L.Close()
+		return nil, err
+	}
+	if L.GetGlobal("on_midi").Type() != lua.LTFunction {
+		L.Close()
+		return nil, fmt.Errorf("%s: no on_midi function", path)
+	}
+	s := &luaScript{L: L}
+	scripts[path] = s
+	return s, nil
+}
+
+// scriptActions calls on_midi(event) in the script of a mapping. It returns
+// a list of messages, each a table {path = "/addr", args = {...}} with an
+// optional types string such as "if"; without it numbers are sent as
+// floats, strings as strings and booleans as T/F.
+func scriptActions(path string, ev MidiEvent) ([]OSCAction, error) {
+	s, err := loadScript(path)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	L := s.L
+	if L == nil {
+		return nil, fmt.Errorf("%s: unloaded by a config reload", path)
+	}
+
+	event := L.NewTable()
+	event.RawSetString("channel", lua.LNumber(ev.Channel))
+	event.RawSetString("cc", lua.LNumber(ev.CC))
+	event.RawSetString("note", lua.LNumber(ev.Note))
+	event.RawSetString("value", lua.LNumber(ev.Value))
+	full := ev.Max
+	if full == 0 {
+		full = 127
+	}
+	event.RawSetString("max", lua.LNumber(full))
+	event.RawSetString("velocity", lua.LNumber(ev.Velocity))
+	event.RawSetString("bend", lua.LNumber(ev.Bend))
+	event.RawSetString("pressure", lua.LNumber(ev.Pressure))
+	if ev.Data != nil {
+		data := L.NewTable()
+		for _, b := range ev.Data {
+			data.Append(lua.LNumber(b))
+		}
+		event.RawSetString("data", data)
+	}
+
+	if err := L.CallByParam(lua.P{Fn: L.GetGlobal("on_midi"), NRet: 1, Protect: true}, event); err != nil {
+		return nil, err
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+
+	msgs, ok := ret.(*lua.LTable)
+	if !ok {
+		return nil, nil // nothing to send
+	}
+	var actions []OSCAction
+	for i := 1; i <= msgs.Len(); i++ {
+		act, err := luaAction(msgs.RawGetInt(i))
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+		actions = append(actions, act)
+	}
+	return actions, nil
+}
+
+func luaAction(v lua.LValue) (OSCAction, error) {
+	t, ok := v.(*lua.LTable)
+	if !ok {
+		return OSCAction{}, fmt.Errorf("expected a table, got %s", v.Type())
+	}
+	path, ok := t.RawGetString("path").(lua.LString)
+	if !ok {
+		return OSCAction{}, fmt.Errorf("missing path")
+	}
+	act := OSCAction{Path: string(path)}
+	types, _ := t.RawGetString("types").(lua.LString)
+	args, _ := t.RawGetString("args").(*lua.LTable)
+	if args == nil {
+		return act, nil
+	}
+	for i := 1; i <= args.Len(); i++ {
+		var arg OSCArg
+		switch a := args.RawGetInt(i).(type) {
+		case lua.LNumber:
+			arg = OSCArg{Type: "f", Value: float64(a)}
+		case lua.LString:
+			arg = OSCArg{Type: "s", Value: string(a)}
+		case lua.LBool:
+			arg = OSCArg{Type: "F"}
+			if a {
+				arg.Type = "T"
+			}
+		default:
+			return act, fmt.Errorf("unsupported argument type %s", a.Type())
+		}
+		if i <= len(types) {
+			arg.Type = string(types[i-1])
+		}
+		act.Args = append(act.Args, arg)
+	}
+	return act, nil
+}
+
+// closeScripts unloads all scripts, which are loaded again on next use.
+func closeScripts() {
+	scriptsMu.Lock()
+	defer scriptsMu.Unlock()
+	for path, s := range scripts {
+		s.mu.Lock()
+		s.L.Close()
+		s.L = nil
+		s.mu.Unlock()
+		delete(scripts, path)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScriptActions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.lua")
+	script := `
+function on_midi(event)
+  return {
+    {path = "/stop"},
+    {path = "/empty", args = {}},
+    {path = "/level", args = {event.value, "x", true}, types = "isT"},
+  }
+end
+`
+	if err := os.WriteFile(path, []byte(script), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	defer closeScripts()
+
+	actions, err := scriptActions(path, MidiEvent{Value: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		path string
+		args []interface{}
+	}{
+		{"/stop", nil},
+		{"/empty", nil},
+		{"/level", []interface{}{int32(64), "x", true}},
+	}
+	if len(actions) != len(want) {
+		t.Fatalf("got %d actions, want %d", len(actions), len(want))
+	}
+	for i, act := range actions {
+		msg, err := buildMessage(act, MidiEvent{})
+		if err != nil {
+			t.Errorf("%s: %v", want[i].path, err)
+			continue
+		}
+		if msg.Address != want[i].path || len(msg.Arguments) != len(want[i].args) {
+			t.Errorf("message %d = %s %v, want %s %v", i, msg.Address, msg.Arguments, want[i].path, want[i].args)
+			continue
+		}
+		for j, arg := range msg.Arguments {
+			if arg != want[i].args[j] {
+				t.Errorf("%s argument %d = %#v, want %#v", msg.Address, j, arg, want[i].args[j])
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultDialTimeout  = 2 * time.Second
+	defaultWriteTimeout = time.Second
+)
+
+// sendErrors counts failed OSC sends by error class, served by the metrics
+// server.
+var sendErrors = expvar.NewMap("osc_send_errors")
+
+func (o TargetOptions) dialTimeout() time.Duration {
+	if o.DialTimeoutMs <= 0 {
+		return defaultDialTimeout
+	}
+	return time.Duration(o.DialTimeoutMs) * time.Millisecond
+}
+
+func (o TargetOptions) writeTimeout() time.Duration {
+	if o.WriteTimeoutMs <= 0 {
+		return defaultWriteTimeout
+	}
+	return time.Duration(o.WriteTimeoutMs) * time.Millisecond
+}
+
+// errorClass sorts a send error into a few classes meaningful to users:
+// refused, timeout, unreachable, dns or other.
+func errorClass(err error) string {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return "refused"
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return "unreachable"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	}
+	return "other"
+}
+
+// countSendError records a failed send and returns its error class.
+func countSendError(err error) string {
+	class := errorClass(err)
+	sendErrors.Add(class, 1)
+	return class
+}
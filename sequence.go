@@ -0,0 +1,25 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+// splitSequence returns the actions of an event up to the first one with
+// a delay. The remaining actions are queued again once the delay has
+// elapsed, so a sequence never blocks the sender.
+func splitSequence(ev MidiEvent) MidiEvent {
+	i := slices.IndexFunc(ev.Actions, func(act OSCAction) bool { return act.DelayMs > 0 })
+	if i < 0 {
+		return ev
+	}
+	next := ev
+	next.step = true
+	next.Actions = slices.Clone(ev.Actions[i:])
+	delay := time.Duration(next.Actions[0].DelayMs) * time.Millisecond
+	next.Actions[0].DelayMs = 0
+	time.AfterFunc(delay, func() { eventChan <- next })
+
+	ev.Actions = ev.Actions[:i]
+	return ev
+}
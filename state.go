@@ -0,0 +1,87 @@
+package main
+
+import "sync"
+
+// controlState is what is known of a CC from both sides: the value last
+// set remotely through OSC feedback and the last physical position.
+type controlState struct {
+	target    uint8
+	hasTarget bool
+	last      uint8
+	hasLast   bool
+	pickedUp  bool
+}
+
+var (
+	controlsMu sync.Mutex
+	controls   [16][128]controlState // by channel and CC number
+	notes      [16][128]uint8        // velocity of held notes, by channel and note number
+)
+
+// setControlTarget records the value a control was set to remotely. Moves
+// of the physical control are then ignored by pickup mappings until it
+// reaches that value.
+func setControlTarget(channel, cc, value uint8) {
+	controlsMu.Lock()
+	defer controlsMu.Unlock()
+	c := &controls[(channel-1)&0x0F][cc&0x7F]
+	c.target, c.hasTarget, c.pickedUp = value, true, false
+}
+
+// pickup records a physical move of a control and reports whether it has
+// caught up with the remote value: reached or crossed it (soft takeover).
+func pickup(channel, cc, value uint8) bool {
+	controlsMu.Lock()
+	defer controlsMu.Unlock()
+	c := &controls[(channel-1)&0x0F][cc&0x7F]
+	if c.hasTarget && !c.pickedUp {
+		crossed := c.hasLast && (c.last < c.target) != (value < c.target)
+		c.pickedUp = value == c.target || crossed
+	}
+	c.last, c.hasLast = value, true
+	return !c.hasTarget || c.pickedUp
+}
+
+// setNoteState records a note being pressed, or released with velocity 0.
+func setNoteState(channel, note, velocity uint8) {
+	controlsMu.Lock()
+	notes[(channel-1)&0x0F][note&0x7F] = velocity
+	controlsMu.Unlock()
+}
+
+// holds reports whether the condition is met on its channel, or on any
+// channel when it has none.
+func (c *Condition) holds() bool {
+	controlsMu.Lock()
+	defer controlsMu.Unlock()
+	for ch := range 16 {
+		if c.Channel != nil && int(*c.Channel) != ch+1 {
+			continue
+		}
+		var value uint8
+		switch {
+		case c.CC != nil:
+			s := controls[ch][*c.CC&0x7F]
+			if !s.hasLast {
+				continue
+			}
+			value = s.last
+		case c.Note != nil:
+			value = notes[ch][*c.Note&0x7F]
+		default:
+			return false
+		}
+		if c.Value == nil && value > 0 || c.Value != nil && value >= c.Value.Min && value <= c.Value.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// ccValue returns the last value received for a CC.
+func ccValue(channel, cc uint8) (uint8, bool) {
+	controlsMu.Lock()
+	defer controlsMu.Unlock()
+	c := controls[(channel-1)&0x0F][cc&0x7F]
+	return c.last, c.hasLast
+}
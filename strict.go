@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// unknownFieldRe matches the yaml.v3 error for a field not in a struct.
+var unknownFieldRe = regexp.MustCompile(`field (\S+) not found in type main\.(\w+)`)
+
+// suggestFields adds a "did you mean" hint to unknown field errors, when
+// a field of the struct has a close name.
+func suggestFields(err error) error {
+	var te *yaml.TypeError
+	if !errors.As(err, &te) {
+		return err
+	}
+	fields := yamlFields(reflect.TypeOf(Config{}), map[string][]string{})
+	for i, e := range te.Errors {
+		m := unknownFieldRe.FindStringSubmatch(e)
+		if m == nil {
+			continue
+		}
+		if s := closest(m[1], fields[m[2]]); s != "" {
+			te.Errors[i] = fmt.Sprintf("%s (did you mean %q?)", e, s)
+		}
+	}
+	return te
+}
+
+// yamlFields collects the YAML field names of t and the structs it uses,
+// by struct name.
+func yamlFields(t reflect.Type, fields map[string][]string) map[string][]string {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		return yamlFields(t.Elem(), fields)
+	case reflect.Struct:
+	default:
+		return fields
+	}
+	if _, ok := fields[t.Name()]; ok {
+		return fields
+	}
+	fields[t.Name()] = nil
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[t.Name()] = append(fields[t.Name()], name)
+		yamlFields(f.Type, fields)
+	}
+	return fields
+}
+
+// closest returns the candidate within 2 edits of name, if any.
+func closest(name string, candidates []string) string {
+	best, bestDist := "", 3
+	for _, c := range candidates {
+		if d := editDistance(name, c); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	return best
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
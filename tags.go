@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+)
+
+// Tags disabled at runtime, with -disable-tags or /midi2osc/tag.
+var (
+	disabledTagsMu sync.Mutex
+	disabledTags   = map[string]bool{}
+)
+
+// enabled reports whether a mapping is enabled and none of its tags is
+// disabled.
+func (m *Mapping) enabled() bool {
+	if m.Enabled != nil && !*m.Enabled {
+		return false
+	}
+	if len(m.Tags) == 0 {
+		return true
+	}
+	disabledTagsMu.Lock()
+	defer disabledTagsMu.Unlock()
+	return !slices.ContainsFunc(m.Tags, func(tag string) bool { return disabledTags[tag] })
+}
+
+// enableTag enables or disables the mappings with a tag.
+func enableTag(tag string, enable bool) {
+	disabledTagsMu.Lock()
+	if enable {
+		delete(disabledTags, tag)
+	} else {
+		disabledTags[tag] = true
+	}
+	disabledTagsMu.Unlock()
+	slog.Info("Tag enabled", slog.String("tag", tag), slog.Bool("enabled", enable))
+}
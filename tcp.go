@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	tcpMinBackoff = 100 * time.Millisecond
+	tcpMaxBackoff = 10 * time.Second
+)
+
+var errNotConnected = errors.New("not connected")
+
+// tcpConn keeps a single TCP connection to an OSC target open, reconnecting
+// with exponential backoff. Packets are queued while the connection is down
+// unless the target policy is "drop".
+type tcpConn struct {
+	addr  string
+	drop  bool
+	slip  bool
+	tls   *tls.Config
+	queue chan []byte
+
+	dialTimeout  time.Duration
+	writeTimeout time.Duration
+
+	mu        sync.Mutex
+	connected bool
+}
+
+var (
+	tcpConnsMu sync.Mutex
+	tcpConns   = map[string]*tcpConn{}
+)
+
+// tcpConnFor returns the connection manager for target, starting it on
+// first use. The connection is encrypted when useTLS is set.
+func tcpConnFor(target, addr string, useTLS bool) (*tcpConn, error) {
+	tcpConnsMu.Lock()
+	defer tcpConnsMu.Unlock()
+
+	if c, ok := tcpConns[target]; ok {
+		return c, nil
+	}
+	opts := targetOptions(target)
+	var conf *tls.Config
+	if useTLS {
+		var err error
+		if conf, err = tlsConfig(addr, opts); err != nil {
+			return nil, err
+		}
+	}
+	size := opts.QueueSize
+	if size <= 0 {
+		size = 256
+	}
+	c := &tcpConn{
+		addr:  addr,
+		drop:  opts.Policy == "drop",
+		slip:  opts.Framing == "slip",
+		tls:   conf,
+		queue: make(chan []byte, size),
+
+		dialTimeout:  opts.dialTimeout(),
+		writeTimeout: opts.writeTimeout(),
+	}
+	tcpConns[target] = c
+	go c.run()
+	return c, nil
+}
+
+func (c *tcpConn) send(packet []byte) error {
+	c.mu.Lock()
+	connected := c.connected
+	c.mu.Unlock()
+	if !connected && c.drop {
+		return errNotConnected
+	}
+
+	select {
+	case c.queue <- packet:
+		return nil
+	default:
+		return errors.New("send queue full")
+	}
+}
+
+func (c *tcpConn) setConnected(connected bool) {
+	c.mu.Lock()
+	c.connected = connected
+	c.mu.Unlock()
+}
+
+func (c *tcpConn) run() {
+	backoff := tcpMinBackoff
+	var pending []byte // packet that failed and must be sent first
+	for {
+		conn, err := c.dial()
+		if err != nil {
+			slog.Warn("OSC TCP connection failed", slog.String("addr", c.addr), slog.Duration("retry", backoff), slog.String("cause", countSendError(err)), slog.Any("err", err))
+			time.Sleep(backoff)
+			backoff = min(2*backoff, tcpMaxBackoff)
+			continue
+		}
+		slog.Info("OSC TCP connected", slog.String("addr", c.addr))
+		backoff = tcpMinBackoff
+		c.setConnected(true)
+
+		for {
+			packet := pending
+			if packet == nil {
+				packet = <-c.queue
+			}
+			if err := c.writeFrame(conn, packet); err != nil {
+				slog.Warn("OSC TCP connection lost", slog.String("addr", c.addr), slog.String("cause", countSendError(err)), slog.Any("err", err))
+				if !c.drop {
+					pending = packet
+				}
+				break
+			}
+			pending = nil
+		}
+		c.setConnected(false)
+		conn.Close()
+	}
+}
+
+func (c *tcpConn) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: c.dialTimeout}
+	if c.tls != nil {
+		return tls.DialWithDialer(dialer, "tcp", c.addr, c.tls)
+	}
+	return dialer.Dial("tcp", c.addr)
+}
+
+// tlsConfig builds the client TLS configuration of a target: a custom CA
+// to verify the server and an optional client certificate.
+func tlsConfig(addr string, opts TargetOptions) (*tls.Config, error) {
+	conf := &tls.Config{ServerName: opts.ServerName}
+	if conf.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		conf.ServerName = host
+	}
+	if opts.CA != "" {
+		pem, err := os.ReadFile(opts.CA)
+		if err != nil {
+			return nil, err
+		}
+		conf.RootCAs = x509.NewCertPool()
+		if !conf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", opts.CA)
+		}
+	}
+	if opts.Cert != "" || opts.Key != "" {
+		cert, err := tls.LoadX509KeyPair(opts.Cert, opts.Key)
+		if err != nil {
+			return nil, err
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+	return conf, nil
+}
+
+// writeFrame sends an OSC packet either prefixed with its size (OSC 1.0)
+// or SLIP encoded (OSC 1.1).
+func (c *tcpConn) writeFrame(conn net.Conn, packet []byte) error {
+	var frame []byte
+	if c.slip {
+		frame = slipEncode(packet)
+	} else {
+		frame = binary.BigEndian.AppendUint32(make([]byte, 0, 4+len(packet)), uint32(len(packet)))
+		frame = append(frame, packet...)
+	}
+	conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	_, err := conn.Write(frame)
+	return err
+}
+
+const (
+	slipEnd    = 0xC0
+	slipEsc    = 0xDB
+	slipEscEnd = 0xDC
+	slipEscEsc = 0xDD
+)
+
+// slipEncode frames a packet with double-ended SLIP (RFC 1055).
+func slipEncode(packet []byte) []byte {
+	frame := make([]byte, 0, len(packet)+2)
+	frame = append(frame, slipEnd)
+	for _, b := range packet {
+		switch b {
+		case slipEnd:
+			frame = append(frame, slipEsc, slipEscEnd)
+		case slipEsc:
+			frame = append(frame, slipEsc, slipEscEsc)
+		default:
+			frame = append(frame, b)
+		}
+	}
+	return append(frame, slipEnd)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+type throttleState struct {
+	last    time.Time
+	pending *MidiEvent
+}
+
+var (
+	throttleMu sync.Mutex
+	throttles  = map[controlKey]*throttleState{}
+)
+
+// controlKey identifies the control an event of a mapping comes from, a
+// mapping on any channel or on a range of notes matching several controls.
+// The state kept by controlKey is cleared on reload.
+type controlKey struct {
+	mapping  *Mapping
+	channel  uint8
+	cc, note uint8
+}
+
+func (ev *MidiEvent) controlKey() controlKey {
+	return controlKey{ev.Mapping, ev.Channel, ev.CC, ev.Note}
+}
+
+func resetThrottles() {
+	throttleMu.Lock()
+	throttles = map[controlKey]*throttleState{}
+	throttleMu.Unlock()
+}
+
+// throttle reports whether an event may be sent now. Events of a mapping
+// with min_interval_ms arriving too fast are coalesced: only the latest one
+// is kept and re-queued once the interval has elapsed.
+func throttle(ev MidiEvent) bool {
+	m := ev.Mapping
+	if m == nil || m.MinIntervalMs <= 0 || ev.coalesced || ev.step || ev.ramped {
+		return true
+	}
+	interval := time.Duration(m.MinIntervalMs) * time.Millisecond
+
+	throttleMu.Lock()
+	defer throttleMu.Unlock()
+
+	k := ev.controlKey()
+	st, ok := throttles[k]
+	if !ok {
+		st = &throttleState{}
+		throttles[k] = st
+	}
+	now := time.Now()
+	if st.pending == nil && now.Sub(st.last) >= interval {
+		st.last = now
+		return true
+	}
+
+	if st.pending == nil {
+		time.AfterFunc(interval-now.Sub(st.last), func() { flushThrottle(st) })
+	}
+	st.pending = &ev
+	return false
+}
+
+func flushThrottle(st *throttleState) {
+	throttleMu.Lock()
+	ev := st.pending
+	st.pending = nil
+	st.last = time.Now()
+	throttleMu.Unlock()
+
+	ev.coalesced = true
+	eventChan <- *ev
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	timersMu   sync.Mutex
+	stopTimers = func() {}
+)
+
+// startTimers sends the actions of each timer at its interval, replacing
+// the timers of a previous config.
+func startTimers(c *Config) {
+	timersMu.Lock()
+	defer timersMu.Unlock()
+	stopTimers()
+
+	done := make(chan struct{})
+	for _, t := range c.Timers {
+		if t.IntervalMs <= 0 || len(t.Actions) == 0 {
+			continue
+		}
+		target := t.OscTarget
+		if len(target) == 0 {
+			target = c.OscTarget
+		}
+		go func() {
+			ticker := time.NewTicker(time.Duration(t.IntervalMs) * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					dispatch(MidiEvent{Target: target, Actions: t.Actions})
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+	stopTimers = func() { close(done) }
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+)
+
+// Toggle states by mapping key. They are kept across config reloads, a
+// reloaded mapping with the same key keeps its state.
+var (
+	togglesMu sync.Mutex
+	toggles   = map[string]bool{}
+)
+
+// key identifies a mapping by its name, or by its bank and the control it
+// listens to.
+func (m *Mapping) key() string {
+	if m.Name != "" {
+		return m.Name
+	}
+	var ch uint8
+	if m.Channel != nil {
+		ch = uint8(*m.Channel)
+	}
+	k := fmt.Sprintf("ch%d", ch)
+	if m.Bank != 0 {
+		k = fmt.Sprintf("bank%d/%s", m.Bank, k)
+	}
+	if m.Port != 0 {
+		k = fmt.Sprintf("port%d/%s", m.Port, k)
+	}
+	if m.profile != "" {
+		k = m.profile + "/" + k
+	}
+	switch {
+	case m.CC != nil:
+		return fmt.Sprintf("%s/cc%d", k, *m.CC)
+	case m.Note != nil:
+		return fmt.Sprintf("%s/note%d", k, *m.Note)
+	case m.Notes != nil:
+		return fmt.Sprintf("%s/notes%d-%d", k, m.Notes.Min, m.Notes.Max)
+	}
+	return k
+}
+
+// mappingAttr names the mapping of an event in logs, nothing for built-in
+// messages.
+func (ev MidiEvent) mappingAttr() slog.Attr {
+	if ev.Mapping == nil {
+		return slog.Attr{}
+	}
+	return slog.String("mapping", ev.Mapping.key())
+}
+
+// toggle flips the state of a toggle mapping on each press and returns the
+// actions for the new state. Releases return false.
+func (m *Mapping) toggle(value int64) ([]OSCAction, bool) {
+	if value == 0 {
+		return nil, false
+	}
+	togglesMu.Lock()
+	defer togglesMu.Unlock()
+	k := m.key()
+	on := !toggles[k]
+	toggles[k] = on
+	if on {
+		return m.Actions, true
+	}
+	return m.OffActions, true
+}
+
+// toggleStates returns a copy of the toggle states, sorted by key.
+func toggleStates() ([]string, map[string]bool) {
+	togglesMu.Lock()
+	defer togglesMu.Unlock()
+	keys := make([]string, 0, len(toggles))
+	states := make(map[string]bool, len(toggles))
+	for k, on := range toggles {
+		keys = append(keys, k)
+		states[k] = on
+	}
+	sort.Strings(keys)
+	return keys, states
+}
@@ -0,0 +1,64 @@
+package main
+
+import "math"
+
+// Transform rescales a numeric action value. The input range is normalized,
+// shaped by the curve and mapped onto the output range.
+type Transform struct {
+	InMin  float64   `yaml:"in_min"`
+	InMax  *float64  `yaml:"in_max"` // 127 when unset
+	OutMin float64   `yaml:"out_min"`
+	OutMax *float64  `yaml:"out_max"` // 1.0 when unset
+	Curve  string    `yaml:"curve"`   // linear (default), log, exp, db or table
+	Table  []float64 `yaml:"table"`   // curve: table, output values spread evenly over the input range
+}
+
+// apply transforms val when it is numeric and returns it unchanged
+// otherwise.
+func (t *Transform) apply(val interface{}) interface{} {
+	in, ok := toFloat(val)
+	if !ok {
+		return val
+	}
+	inMax, outMax := 127.0, 1.0
+	if t.InMax != nil {
+		inMax = *t.InMax
+	}
+	if t.OutMax != nil {
+		outMax = *t.OutMax
+	}
+
+	x := 0.0
+	if inMax != t.InMin {
+		x = (in - t.InMin) / (inMax - t.InMin)
+	}
+	x = math.Max(0, math.Min(1, x))
+
+	switch t.Curve {
+	case "log":
+		x = math.Log10(1 + 9*x)
+	case "exp":
+		x = (math.Pow(10, x) - 1) / 9
+	case "db":
+		// Output bounds are in dB, interpolate linearly in amplitude
+		lo, hi := math.Pow(10, t.OutMin/20), math.Pow(10, outMax/20)
+		return 20 * math.Log10(lo+x*(hi-lo))
+	case "table":
+		return interpolate(t.Table, x)
+	}
+	return t.OutMin + x*(outMax-t.OutMin)
+}
+
+// interpolate reads the piecewise linear curve through table at x in 0..1.
+func interpolate(table []float64, x float64) float64 {
+	switch len(table) {
+	case 0:
+		return x
+	case 1:
+		return table[0]
+	}
+	pos := x * float64(len(table)-1)
+	i := min(int(pos), len(table)-2)
+	frac := pos - float64(i)
+	return table[i] + frac*(table[i+1]-table[i])
+}
@@ -0,0 +1,85 @@
+package main
+
+import "encoding/binary"
+
+// jackPortIsMIDI2 is the PipeWire JACK extension flag requesting Universal
+// MIDI Packets instead of MIDI 1.0 bytes on a MIDI port.
+const jackPortIsMIDI2 = 0x20
+
+// umpWords is the packet size in 32-bit words for each message type.
+var umpWords = [16]int{1, 1, 1, 2, 2, 4, 1, 1, 2, 2, 2, 3, 3, 4, 4, 4}
+
+// umpDecoder converts Universal MIDI Packets to MIDI 1.0 messages for the
+// mapping engine, keeping the full MIDI 2.0 value resolution aside. It is
+// not safe for concurrent use.
+type umpDecoder struct {
+	sysex []byte
+}
+
+// feed decodes native-endian UMP words and calls emit for every message.
+func (d *umpDecoder) feed(data []byte, emit func([]byte, hiRes)) {
+	for len(data) >= 4 {
+		w0 := binary.NativeEndian.Uint32(data)
+		n := umpWords[w0>>28]
+		if len(data) < 4*n {
+			return // truncated packet
+		}
+		var w1 uint32
+		if n > 1 {
+			w1 = binary.NativeEndian.Uint32(data[4:])
+		}
+		d.decode(w0, w1, emit)
+		data = data[4*n:]
+	}
+}
+
+func (d *umpDecoder) decode(w0, w1 uint32, emit func([]byte, hiRes)) {
+	status := byte(w0 >> 16)
+	b1, b2 := byte(w0>>8)&0x7F, byte(w0)&0x7F
+
+	switch w0 >> 28 {
+	case 0x1, 0x2: // System and MIDI 1.0 channel voice messages
+		msg := []byte{status, b1, b2}
+		emit(msg[:messageLen(status)], hiRes{})
+	case 0x3: // 7-bit SysEx in 64-bit packets
+		count := int(w0>>16) & 0x0F
+		payload := []byte{byte(w0 >> 8), byte(w0), byte(w1 >> 24), byte(w1 >> 16), byte(w1 >> 8), byte(w1)}
+		payload = payload[:min(count, 6)]
+		switch (w0 >> 20) & 0x0F {
+		case 0x0: // Complete
+			emit(append(append([]byte{0xF0}, payload...), 0xF7), hiRes{})
+		case 0x1: // Start
+			d.sysex = append([]byte{0xF0}, payload...)
+		case 0x2: // Continue
+			if d.sysex != nil {
+				d.sysex = append(d.sysex, payload...)
+			}
+		case 0x3: // End
+			if d.sysex != nil {
+				emit(append(append(d.sysex, payload...), 0xF7), hiRes{})
+				d.sysex = nil
+			}
+		}
+	case 0x4: // MIDI 2.0 channel voice messages
+		st := status & 0xF0
+		switch st {
+		case 0x80, 0x90: // Note Off/On, 16-bit velocity
+			vel := w1 >> 16
+			vel7 := byte(vel >> 9)
+			if st == 0x90 && vel7 == 0 {
+				// MIDI 2.0 Note On never means Note Off, even at velocity 0
+				vel7, vel = 1, max(vel, 1)
+			}
+			emit([]byte{status, b1, vel7}, hiRes{int64(vel), 0xFFFF})
+		case 0xA0, 0xB0: // Poly pressure, CC, 32-bit data
+			emit([]byte{status, b1, byte(w1 >> 25)}, hiRes{int64(w1), 0xFFFFFFFF})
+		case 0xC0: // Program change
+			emit([]byte{status, byte(w1>>24) & 0x7F}, hiRes{})
+		case 0xD0: // Channel pressure
+			emit([]byte{status, byte(w1 >> 25)}, hiRes{int64(w1), 0xFFFFFFFF})
+		case 0xE0: // Pitch bend, 32-bit centered on 0x80000000
+			bend := w1 >> 18
+			emit([]byte{status, byte(bend) & 0x7F, byte(bend>>7) & 0x7F}, hiRes{int64(w1), 0xFFFFFFFF})
+		}
+	}
+}
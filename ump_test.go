@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func umpBytes(words ...uint32) []byte {
+	var b []byte
+	for _, w := range words {
+		b = binary.NativeEndian.AppendUint32(b, w)
+	}
+	return b
+}
+
+func TestUmpDecoder(t *testing.T) {
+	type event struct {
+		msg []byte
+		hr  hiRes
+	}
+	tests := []struct {
+		name  string
+		words []uint32
+		want  []event
+	}{
+		{
+			name:  "midi 1.0 note on",
+			words: []uint32{0x20903C64},
+			want:  []event{{msg: []byte{0x90, 0x3C, 0x64}}},
+		},
+		{
+			name:  "midi 1.0 program change",
+			words: []uint32{0x20C10500},
+			want:  []event{{msg: []byte{0xC1, 0x05}}},
+		},
+		{
+			name:  "system realtime",
+			words: []uint32{0x10F80000},
+			want:  []event{{msg: []byte{0xF8}}},
+		},
+		{
+			name:  "midi 2.0 note on",
+			words: []uint32{0x40903C00, 0xFFFF0000},
+			want:  []event{{msg: []byte{0x90, 0x3C, 0x7F}, hr: hiRes{0xFFFF, 0xFFFF}}},
+		},
+		{
+			name:  "midi 2.0 note on with a tiny velocity",
+			words: []uint32{0x40903C00, 0x00010000},
+			want:  []event{{msg: []byte{0x90, 0x3C, 0x01}, hr: hiRes{1, 0xFFFF}}},
+		},
+		{
+			name:  "midi 2.0 note on with velocity 0",
+			words: []uint32{0x40903C00, 0x00000000},
+			want:  []event{{msg: []byte{0x90, 0x3C, 0x01}, hr: hiRes{1, 0xFFFF}}},
+		},
+		{
+			name:  "midi 2.0 note off",
+			words: []uint32{0x40803C00, 0x80000000},
+			want:  []event{{msg: []byte{0x80, 0x3C, 0x40}, hr: hiRes{0x8000, 0xFFFF}}},
+		},
+		{
+			name:  "midi 2.0 cc",
+			words: []uint32{0x40B10700, 0x80000000},
+			want:  []event{{msg: []byte{0xB1, 0x07, 0x40}, hr: hiRes{0x80000000, 0xFFFFFFFF}}},
+		},
+		{
+			name:  "midi 2.0 cc full scale",
+			words: []uint32{0x40B10700, 0xFFFFFFFF},
+			want:  []event{{msg: []byte{0xB1, 0x07, 0x7F}, hr: hiRes{0xFFFFFFFF, 0xFFFFFFFF}}},
+		},
+		{
+			name:  "midi 2.0 program change",
+			words: []uint32{0x40C00000, 0x05000000},
+			want:  []event{{msg: []byte{0xC0, 0x05}}},
+		},
+		{
+			name:  "midi 2.0 pitch bend centered",
+			words: []uint32{0x40E00000, 0x80000000},
+			want:  []event{{msg: []byte{0xE0, 0x00, 0x40}, hr: hiRes{0x80000000, 0xFFFFFFFF}}},
+		},
+		{
+			name:  "complete sysex",
+			words: []uint32{0x30037E7F, 0x06000000},
+			want:  []event{{msg: []byte{0xF0, 0x7E, 0x7F, 0x06, 0xF7}}},
+		},
+		{
+			name:  "sysex in several packets",
+			words: []uint32{0x30160102, 0x03040506, 0x30260708, 0x090A0B0C, 0x30310D00, 0x00000000},
+			want:  []event{{msg: []byte{0xF0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 0xF7}}},
+		},
+		{
+			name:  "sysex continue without start",
+			words: []uint32{0x30260708, 0x090A0B0C, 0x30310D00, 0x00000000},
+		},
+		{
+			name: "packets of every size are skipped",
+			words: []uint32{
+				// Utility, data, reserved, flex data and stream packets
+				0x00000000,
+				0x50000000, 0, 0, 0,
+				0x60000000,
+				0x80000000, 0,
+				0xB0000000, 0, 0,
+				0xD0000000, 0, 0, 0,
+				0xF0000000, 0, 0, 0,
+				// MIDI 1.0 CC
+				0x20B00102,
+			},
+			want: []event{{msg: []byte{0xB0, 0x01, 0x02}}},
+		},
+		{
+			name:  "truncated packet",
+			words: []uint32{0x20903C64, 0x40903C00},
+			want:  []event{{msg: []byte{0x90, 0x3C, 0x64}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d umpDecoder
+			var got []event
+			d.feed(umpBytes(tt.words...), func(msg []byte, hr hiRes) {
+				got = append(got, event{msg, hr})
+			})
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !bytes.Equal(got[i].msg, tt.want[i].msg) || got[i].hr != tt.want[i].hr {
+					t.Errorf("event %d = % X %v, want % X %v", i, got[i].msg, got[i].hr, tt.want[i].msg, tt.want[i].hr)
+				}
+			}
+		})
+	}
+}
+
+func TestUmpWords(t *testing.T) {
+	// Packet sizes of the UMP specification, by message type
+	want := map[uint32]int{
+		0x0: 1, 0x1: 1, 0x2: 1, 0x3: 2, 0x4: 2, 0x5: 4,
+		0xD: 4, 0xF: 4,
+	}
+	for typ, n := range want {
+		if umpWords[typ] != n {
+			t.Errorf("umpWords[%X] = %d, want %d", typ, umpWords[typ], n)
+		}
+	}
+}
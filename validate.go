@@ -0,0 +1,421 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// diagnostic is a problem found in a config file.
+type diagnostic struct {
+	file  string
+	line  int    // 0 when unknown
+	level string // "error" or "warning"
+	msg   string
+}
+
+// place is the file and line of a config element.
+type place struct {
+	file string
+	line int
+}
+
+// String formats p for a message about file, as a line of it or as
+// file:line.
+func (p place) String(file string) string {
+	switch {
+	case p.line == 0:
+		return p.file
+	case p.file == file:
+		return fmt.Sprintf("line %d", p.line)
+	}
+	return fmt.Sprintf("%s:%d", p.file, p.line)
+}
+
+type validator struct {
+	diags    []diagnostic
+	targets  map[string]place // first use of each target
+	seen     map[string]place // first mapping of each control and value, see duplicateKey
+	profiles map[string][]Mapping
+
+	file string
+	// TOML files are checked in their YAML conversion, whose lines are
+	// replaced by the line of the current mapping, 0 when unknown
+	toml bool
+	line int
+}
+
+func (v *validator) at(line int) place {
+	if v.toml {
+		line = v.line
+	}
+	return place{v.file, line}
+}
+
+func (v *validator) errorf(line int, format string, args ...interface{}) {
+	p := v.at(line)
+	v.diags = append(v.diags, diagnostic{p.file, p.line, "error", fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) warnf(line int, format string, args ...interface{}) {
+	p := v.at(line)
+	v.diags = append(v.diags, diagnostic{p.file, p.line, "warning", fmt.Sprintf(format, args...)})
+}
+
+// runValidate implements "midi2osc validate <file>": it checks a config
+// and the files it includes, and prints line-numbered diagnostics. It
+// returns the exit status.
+func runValidate(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: midi2osc validate <file>")
+		return 2
+	}
+	path := args[0]
+	c, err := loadConfig(path)
+	if err != nil {
+		fmt.Printf("%s: error: %v\n", path, err)
+		return 1
+	}
+	diags, err := validateConfig(path, c)
+	if err != nil {
+		fmt.Printf("%s: error: %v\n", path, err)
+		return 1
+	}
+	status := 0
+	for _, d := range diags {
+		if d.line > 0 {
+			fmt.Printf("%s:%d: %s: %s\n", d.file, d.line, d.level, d.msg)
+		} else {
+			fmt.Printf("%s: %s: %s\n", d.file, d.level, d.msg)
+		}
+		if d.level == "error" {
+			status = 1
+		}
+	}
+	if len(diags) == 0 {
+		fmt.Printf("%s: OK\n", path)
+	}
+	return status
+}
+
+// validateConfig checks the mappings of the config file at path and of
+// the files it includes, c being the merged config.
+func validateConfig(path string, c *Config) ([]diagnostic, error) {
+	v := &validator{targets: map[string]place{}, seen: map[string]place{}, profiles: c.Profiles}
+	var files []string
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		files = append(files, path)
+	}
+	abs, _ := filepath.Abs(path)
+	for _, f := range c.files {
+		if info, err := os.Stat(f); f != abs && err == nil && !info.IsDir() {
+			files = append(files, displayPath(f))
+		}
+	}
+	for _, f := range files {
+		b, err := readConfig(f)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+		var tomlLines map[string][]int
+		if filepath.Ext(f) == ".toml" {
+			raw, _ := os.ReadFile(f) // no lines for a URL
+			tomlLines = tomlTables(raw)
+		}
+		if err := v.check(f, b, tomlLines); err != nil {
+			return nil, fmt.Errorf("%s: %w", f, err)
+		}
+	}
+	v.reachable()
+	order := func(d diagnostic) int { return slices.Index(files, d.file) }
+	slices.SortStableFunc(v.diags, func(a, b diagnostic) int {
+		return cmp.Or(cmp.Compare(order(a), order(b)), cmp.Compare(a.line, b.line))
+	})
+	return v.diags, nil
+}
+
+// displayPath shortens an absolute path to a path relative to the current
+// directory when it is below it.
+func displayPath(abs string) string {
+	wd, err := os.Getwd()
+	if err != nil {
+		return abs
+	}
+	if rel, err := filepath.Rel(wd, abs); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return abs
+}
+
+// check adds the diagnostics of a config file, b being its content in
+// YAML and tomlLines the lines of its arrays of tables, see tomlTables.
+func (v *validator) check(file string, b []byte, tomlLines map[string][]int) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	v.file, v.toml, v.line = file, filepath.Ext(file) == ".toml", 0
+	root := doc.Content[0]
+
+	if n := mapValue(root, "osc_target"); n != nil {
+		var targets TargetList
+		if err := n.Decode(&targets); err != nil {
+			v.errorf(n.Line, "%v", err)
+		}
+		for _, t := range targets {
+			v.target(n.Line, t)
+		}
+	}
+	if n := mapValue(root, "mappings"); n != nil {
+		v.mappings(n, "", tomlLines["mappings"])
+	}
+	if n := mapValue(root, "profiles"); n != nil && n.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			name := n.Content[i].Value
+			v.mappings(n.Content[i+1], name, tomlLines["profiles."+name])
+		}
+	}
+	v.line = 0
+	return nil
+}
+
+// tomlTables returns the lines of the [[name]] headers of a TOML file by
+// name, the lines of the items of the arrays of tables such as mappings.
+func tomlTables(b []byte) map[string][]int {
+	lines := map[string][]int{}
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if name, ok := strings.CutPrefix(line, "[["); ok && strings.HasSuffix(name, "]]") {
+			name = strings.TrimSpace(strings.TrimSuffix(name, "]]"))
+			lines[name] = append(lines[name], i+1)
+		}
+	}
+	return lines
+}
+
+// mappings checks the shared mappings or those of a profile, tomlLines
+// being their lines in a TOML file.
+func (v *validator) mappings(seq *yaml.Node, profile string, tomlLines []int) {
+	for i, node := range seq.Content {
+		v.line = 0
+		if i < len(tomlLines) && len(tomlLines) == len(seq.Content) {
+			v.line = tomlLines[i]
+		}
+		var m Mapping
+		if err := node.Decode(&m); err != nil {
+			v.errorf(node.Line, "%v", err)
+			continue
+		}
+		if m.CC == nil && m.Note == nil && m.Notes == nil && !m.PitchBend && !m.Aftertouch && !m.PolyAftertouch &&
+			m.NRPN == nil && m.RPN == nil && m.SysEx == nil && m.MMC == "" && len(m.Combine) == 0 && m.Control == "" {
+			v.warnf(node.Line, "mapping matches no MIDI message")
+		}
+		if m.Channel != nil && (*m.Channel < 1 || *m.Channel > 16) {
+			v.errorf(node.Line, "channel %d out of 1-16", *m.Channel)
+		}
+		if m.CC != nil || m.Note != nil {
+			m.profile = profile
+			k := duplicateKey(&m)
+			if first, ok := v.seen[k]; ok && m.When == nil {
+				v.warnf(node.Line, "same control and value as the mapping at %s", first.String(v.file))
+			} else if m.When == nil {
+				v.seen[k] = v.at(node.Line)
+			}
+		}
+		if n := mapValue(node, "osc_target"); n != nil {
+			for _, t := range m.OscTarget {
+				v.target(n.Line, t)
+			}
+		}
+		for _, key := range []string{"actions", "off_actions", "on_tap", "on_double_tap", "on_hold"} {
+			if n := mapValue(node, key); n != nil {
+				v.actions(n)
+			}
+		}
+	}
+}
+
+// duplicateKey identifies the control and value a mapping fires on, so
+// that a control split in value ranges or with an edge trigger is not
+// reported.
+func duplicateKey(m *Mapping) string {
+	k := m.key()
+	if m.Name != "" {
+		m2 := *m
+		m2.Name = ""
+		k = m2.key()
+	}
+	lo, hi := 0, 127
+	if m.Value != nil {
+		lo, hi = int(m.Value.Min), int(m.Value.Max)
+	}
+	if m.ValueMin != nil {
+		lo = max(lo, int(*m.ValueMin))
+	}
+	if m.ValueMax != nil {
+		hi = min(hi, int(*m.ValueMax))
+	}
+	if lo != 0 || hi != 127 {
+		k += fmt.Sprintf("/%d-%d", lo, hi)
+	}
+	if m.Threshold != nil {
+		k += fmt.Sprintf("/threshold%d-%s", *m.Threshold, m.Edge)
+	}
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{{m.CC14, "cc14"}, {m.PolyAftertouch, "poly_aftertouch"}, {m.PitchBend, "pitchbend"}} {
+		if f.set {
+			k += "/" + f.name
+		}
+	}
+	return k
+}
+
+func (v *validator) actions(seq *yaml.Node) {
+	for _, node := range seq.Content {
+		var act OSCAction
+		if err := node.Decode(&act); err != nil {
+			v.errorf(node.Line, "%v", err)
+			continue
+		}
+		if n := mapValue(node, "osc_target"); n != nil {
+			for _, t := range act.OscTarget {
+				v.target(n.Line, t)
+			}
+		}
+		if act.Profile != "" {
+			if _, ok := v.profiles[act.Profile]; !ok {
+				v.errorf(node.Line, "unknown profile %q", act.Profile)
+			}
+			continue
+		}
+		if act.MQTT != nil {
+			if act.MQTT.Topic == "" {
+				v.errorf(node.Line, "mqtt action without topic")
+			}
+			if act.MQTT.QoS > 2 {
+				v.errorf(node.Line, "mqtt qos %d, expected 0, 1 or 2", act.MQTT.QoS)
+			}
+			continue
+		}
+		if act.HTTP != nil {
+			u, err := url.Parse(act.HTTP.URL)
+			if !strings.HasPrefix(act.HTTP.URL, "${") && (err != nil || (u.Scheme != "http" && u.Scheme != "https")) {
+				v.errorf(node.Line, "http action url %q must be an http or https URL", act.HTTP.URL)
+			}
+			continue
+		}
+		if act.Exec != nil {
+			if len(act.Exec.Command) == 0 {
+				v.errorf(node.Line, "exec action without command")
+			} else if _, err := exec.LookPath(act.Exec.Command[0]); err != nil && !strings.Contains(act.Exec.Command[0], "{{") {
+				v.warnf(node.Line, "command %q not found", act.Exec.Command[0])
+			}
+			continue
+		}
+		if act.ArtNet != nil {
+			if act.ArtNet.Channel < 1 || act.ArtNet.Channel > 512 {
+				v.errorf(node.Line, "artnet channel %d out of 1-512", act.ArtNet.Channel)
+			}
+			if act.ArtNet.Universe > 0x7FFF {
+				v.errorf(node.Line, "artnet universe %d out of 0-32767", act.ArtNet.Universe)
+			}
+			continue
+		}
+		if act.Macro != "" || act.Midi != nil || act.Led != nil || act.Set != nil {
+			continue
+		}
+		switch {
+		case act.Path == "":
+			v.errorf(node.Line, "action without path")
+			continue
+		case !strings.HasPrefix(act.Path, "/"):
+			v.errorf(node.Line, "OSC path %q must start with /", act.Path)
+			continue
+		case strings.ContainsAny(act.Path, " #,"):
+			v.errorf(node.Line, "OSC path %q contains a space, # or ,", act.Path)
+			continue
+		}
+		// Building the message with a blank event checks the types against
+		// the values, except for templates only known at runtime
+		if len(act.Args) == 0 && (act.Type != "" || act.Value != nil) {
+			act.Args = []OSCArg{{Type: act.Type, Value: act.Value, Transform: act.Transform}}
+		}
+		for i, arg := range act.Args {
+			if s, ok := arg.Value.(string); ok && strings.Contains(s, "{{") {
+				act.Args[i].Value = "0"
+			}
+		}
+		ev := MidiEvent{Data: make([]byte, 256), Values: make([]int, 16)}
+		if _, err := buildMessage(act, ev); err != nil {
+			v.errorf(node.Line, "%s: %v", act.Path, err)
+		}
+	}
+}
+
+// target checks the syntax of a target, its reachability being checked
+// once for all by reachable.
+func (v *validator) target(line int, target string) {
+	if strings.HasPrefix(target, "mdns://") {
+		return
+	}
+	if _, _, err := splitTarget(target); err != nil {
+		v.errorf(line, "%v", err)
+		return
+	}
+	if _, ok := v.targets[target]; !ok {
+		v.targets[target] = v.at(line)
+	}
+}
+
+// reachable resolves the host of UDP targets and connects to TCP targets.
+func (v *validator) reachable() {
+	for target, p := range v.targets {
+		v.file, v.toml = p.file, false
+		line := p.line
+		transport, addr, _ := splitTarget(target)
+		switch transport {
+		case "udp":
+			if _, err := net.ResolveUDPAddr("udp", addr); err != nil {
+				v.warnf(line, "target %s unreachable: %v", target, err)
+			}
+		case "tcp", "tcps":
+			conn, err := net.DialTimeout("tcp", addr, time.Second)
+			if err != nil {
+				v.warnf(line, "target %s unreachable: %v", target, err)
+				continue
+			}
+			conn.Close()
+		case "unix":
+			if _, err := os.Stat(addr); err != nil {
+				v.warnf(line, "target %s unreachable: %v", target, err)
+			}
+		}
+	}
+}
+
+// mapValue returns the value of key in a YAML mapping node.
+func mapValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidateIncludes(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"main.yaml": `include: [inc.yaml, more.toml]
+mappings:
+  - cc: 1
+    actions: [{path: /a, type: f, value: $midi}]
+`,
+		"inc.yaml": `mappings:
+  - cc: 1
+    actions: [{path: /b, type: f, value: $midi}]
+  - cc: 2
+    actions: [{path: nope, type: f, value: $midi}]
+`,
+		"more.toml": `# comment
+
+[[mappings]]
+cc = 3
+channel = 17
+
+[[mappings.actions]]
+path = "/c"
+type = "f"
+value = "$midi"
+`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "main.yaml")
+	c, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diags, err := validateConfig(path, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		file  string
+		line  int
+		level string
+	}{
+		{"inc.yaml", 2, "warning"}, // same cc as main.yaml
+		{"inc.yaml", 5, "error"},   // path without /
+		{"more.toml", 3, "error"},  // channel 17
+	}
+	if len(diags) != len(want) {
+		t.Fatalf("got %v, want %d diagnostics", diags, len(want))
+	}
+	for i, d := range diags {
+		if filepath.Base(d.file) != want[i].file || d.line != want[i].line || d.level != want[i].level {
+			t.Errorf("diagnostic %d = %s:%d: %s: %s, want %s:%d: %s", i, d.file, d.line, d.level, d.msg, want[i].file, want[i].line, want[i].level)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"maps"
+	"sync"
+)
+
+// Variables shared between mappings: set by actions, read in templates as
+// {{var.name}} and in expressions as var.name.
+var (
+	varsMu sync.Mutex
+	vars   = map[string]interface{}{}
+)
+
+// initVariables sets the initial values of a config. Variables already set
+// keep their value across reloads.
+func initVariables(c *Config) {
+	varsMu.Lock()
+	defer varsMu.Unlock()
+	for name, val := range c.Variables {
+		if _, ok := vars[name]; !ok {
+			vars[name] = val
+		}
+	}
+}
+
+// setVariables runs a set action, values being resolved like OSC values.
+func setVariables(set map[string]interface{}, ev MidiEvent) {
+	varsMu.Lock()
+	defer varsMu.Unlock()
+	for name, val := range set {
+		vars[name] = resolveValue(val, ev)
+		slog.Debug("Variable set", slog.String("name", name), slog.Any("val", vars[name]))
+	}
+}
+
+func variable(name string) (string, bool) {
+	varsMu.Lock()
+	defer varsMu.Unlock()
+	val, ok := vars[name]
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprint(val), true
+}
+
+func variables() map[string]interface{} {
+	varsMu.Lock()
+	defer varsMu.Unlock()
+	return maps.Clone(vars)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// send calls the URL of an action triggered by ev, without waiting for the
+// response. The URL, header values and body are templates.
+func (ha *HTTPAction) send(ev MidiEvent) {
+	method := strings.ToUpper(ha.Method)
+	if method == "" {
+		method = http.MethodGet
+		if ha.Body != "" {
+			method = http.MethodPost
+		}
+	}
+	url := expandTemplate(ha.URL, ev)
+	req, err := http.NewRequest(method, url, strings.NewReader(expandTemplate(ha.Body, ev)))
+	if err != nil {
+		slog.Error("Failed to build HTTP request", ev.mappingAttr(), slog.Any("err", err))
+		return
+	}
+	for k, v := range ha.Headers {
+		req.Header.Set(k, expandTemplate(v, ev))
+	}
+	attr := ev.mappingAttr()
+	go func() {
+		resp, err := webhookClient.Do(req)
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode >= 300 {
+				err = fmt.Errorf("%s", resp.Status)
+			}
+		}
+		if err != nil {
+			slog.Error("HTTP request failed", attr, slog.String("method", method), slog.String("url", url), slog.Any("err", err))
+			return
+		}
+		slog.Info("HTTP sent", attr, slog.String("method", method), slog.String("url", url), slog.Int("status", resp.StatusCode))
+	}()
+}
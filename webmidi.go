@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/fjammes/midi2osc/resources"
+	"golang.org/x/net/websocket"
+)
+
+const defaultWebMIDIListen = "localhost:8090"
+
+// startWebMIDI serves the page on / and the WebSocket on /midi, whose
+// binary frames carry raw MIDI bytes. Only the page itself and the
+// configured origins may connect, any other page the user visits could
+// play the mappings otherwise.
+func startWebMIDI(conf *WebMIDIConfig) error {
+	listen := conf.Listen
+	if listen == "" {
+		listen = defaultWebMIDIListen
+	}
+	ln, err := net.Listen("tcp", listen)
+	if err != nil {
+		return err
+	}
+	slog.Info("WebMIDI server listening", slog.String("addr", ln.Addr().String()))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if !conf.allowed(r) {
+			http.Error(w, "invalid token", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write([]byte(resources.WebMIDIPage))
+	})
+	mux.Handle("/midi", websocket.Server{
+		Handshake: func(_ *websocket.Config, r *http.Request) error {
+			if !conf.allowed(r) {
+				return fmt.Errorf("invalid token")
+			}
+			return conf.checkOrigin(r)
+		},
+		Handler: serveWebMIDI,
+	})
+	go http.Serve(ln, mux)
+	return nil
+}
+
+func (conf *WebMIDIConfig) allowed(r *http.Request) bool {
+	return conf.Token == "" || subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(conf.Token)) == 1
+}
+
+// checkOrigin accepts the page served at the same address and the
+// configured origins.
+func (conf *WebMIDIConfig) checkOrigin(r *http.Request) error {
+	origin := strings.TrimSuffix(r.Header.Get("Origin"), "/")
+	if u, err := url.Parse(origin); err == nil && u.Host == r.Host || slices.Contains(conf.Origins, origin) {
+		return nil
+	}
+	slog.Warn("WebMIDI connection refused", slog.String("remote", r.RemoteAddr), slog.String("origin", origin))
+	return fmt.Errorf("origin %q not allowed", origin)
+}
+
+func serveWebMIDI(ws *websocket.Conn) {
+	remote := ws.Request().RemoteAddr
+	slog.Info("WebMIDI client connected", slog.String("remote", remote))
+	var parser midiParser
+	for {
+		var data []byte
+		if err := websocket.Message.Receive(ws, &data); err != nil {
+			slog.Info("WebMIDI client disconnected", slog.String("remote", remote))
+			return
+		}
+		parser.feed(data, func(msg []byte) { queueRemote(webMIDIPort, msg) })
+	}
+}